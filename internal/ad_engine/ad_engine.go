@@ -1,97 +1,360 @@
 package ad_engine
 
 import (
+	"container/heap"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/kriscampos/adserver/internal/ad_engine/ordered_multi_list"
 	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/clock"
+	"github.com/kriscampos/adserver/internal/keyword"
 )
 
 // AdEngine produces relevant campaigns from a body of campaigns and keywords.
+//
+// AdEngine is safe for concurrent use. Recommendations only read the index and
+// run in parallel, while registering, updating and deleting campaigns as well
+// as scheduled updates take turns changing it.
 type AdEngine struct {
-	updateTicker        *time.Ticker
-	updateFunctions     map[int64][]func()
-	closeUpdater        chan bool
-	campaignManager     *ordered_multi_list.OrderedMultiList
-	impressionURLToNode map[string]*ordered_multi_list.Node
-}
-
-func NewAdEngine() *AdEngine {
-	return &AdEngine{
-		updateFunctions:     make(map[int64][]func()),
-		campaignManager:     ordered_multi_list.NewOrderedMultiList(),
-		impressionURLToNode: make(map[string]*ordered_multi_list.Node),
+	mu               sync.RWMutex
+	clock            clock.Clock
+	updateTicker     clock.Ticker
+	scheduler        *scheduler
+	closeUpdater     chan bool
+	campaignManager  *ordered_multi_list.OrderedMultiList
+	campaignIDToNode map[int]*ordered_multi_list.Node
+	targetings       map[int]*targeting
+	campaignEvents   map[int][]eventID
+	normalizer       *keyword.Normalizer
+	policy           RankingPolicy
+	floorCPM         float64
+	bidIncrement     float64
+	// Allocation of traffic to guaranteed campaigns, recomputed every
+	// allocationInterval when there is a forecaster.
+	forecaster         SupplyForecaster
+	remaining          func(*campaign.Campaign) int
+	allocationInterval time.Duration
+	plan               *AllocationPlan
+}
+
+// Configures optional behavior of an AdEngine.
+type Option func(*AdEngine)
+
+// Sets the clock used to activate and deactivate campaigns.
+func WithClock(c clock.Clock) Option {
+	return func(a *AdEngine) {
+		a.clock = c
+	}
+}
+
+// Sets the normalizer applied to campaign keywords when campaigns are inserted
+// and to requested keywords when recommending campaigns.
+func WithNormalizer(normalizer *keyword.Normalizer) Option {
+	return func(a *AdEngine) {
+		a.normalizer = normalizer
+	}
+}
+
+func NewAdEngine(opts ...Option) *AdEngine {
+	a := &AdEngine{
+		clock:              clock.New(),
+		scheduler:          newScheduler(),
+		campaignIDToNode:   make(map[int]*ordered_multi_list.Node),
+		targetings:         make(map[int]*targeting),
+		campaignEvents:     make(map[int][]eventID),
+		normalizer:         keyword.NewNormalizer(keyword.DefaultConfig()),
+		policy:             ByTier(ECPMPolicy{}),
+		floorCPM:           DefaultFloorCPM,
+		bidIncrement:       DefaultBidIncrement,
+		remaining:          registeredRemaining,
+		allocationInterval: DefaultAllocationInterval,
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	a.campaignManager = ordered_multi_list.NewOrderedMultiList(ordered_multi_list.WithOrdering(listOrdering{a.policy}))
+	return a
 }
 
-// Begins activation / deactivation management for campaigns.
+// Begins activation / deactivation management for campaigns, and allocating
+// traffic to guaranteed campaigns when there is a forecaster.
 func (a *AdEngine) Start() {
-	a.updateTicker = time.NewTicker(time.Second)
+	a.updateTicker = a.clock.NewTicker(time.Second)
 	a.closeUpdater = make(chan bool)
+	nextPlan := a.clock.Now()
 	go func() {
 		for {
 			select {
-			case t := <-a.updateTicker.C:
-				updateFunctions, ok := a.updateFunctions[t.Unix()]
-				if ok {
-					for _, updateFunction := range updateFunctions {
-						updateFunction()
-					}
+			case <-a.updateTicker.C():
+				now := a.clock.Now()
+				a.runUpdates(now)
+				if a.forecaster != nil && !now.Before(nextPlan) {
+					a.replan(now)
+					nextPlan = now.Add(a.allocationInterval)
 				}
 			case <-a.closeUpdater:
 				a.updateTicker.Stop()
+				return
 			}
 		}
 	}()
 }
 
+// Executes every update that is due by the given time, including ones missed
+// because ticks were dropped.
+func (a *AdEngine) runUpdates(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.scheduler.runDue(now)
+}
+
 func (a *AdEngine) Stop() {
 	a.closeUpdater <- true
 }
 
 // Registers a campaign to be activated or deactivated based on its start and end timestamp.
+// Campaigns that are not live, e.g. drafts or paused campaigns, are ignored, and campaigns
+// that reached a daily cap are activated when the cap resets.
 func (a *AdEngine) RegisterCampaign(campaign *campaign.Campaign) {
-	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.registerCampaign(campaign)
+}
+
+func (a *AdEngine) registerCampaign(campaign *campaign.Campaign) {
+	if !campaign.IsLive() {
+		return
+	}
+	now := a.clock.Now()
+	if !now.Before(campaign.EndTimestamp) {
+		return
+	}
 
+	t := newTargeting(campaign, a.normalizer)
 	campaignNode := ordered_multi_list.NewNode(campaign)
-	a.impressionURLToNode[campaign.ImpressionURL] = campaignNode
-	if now.Before(campaign.StartTimestamp) {
-		a.updateFunctions[campaign.StartTimestamp.Unix()] = append(a.updateFunctions[campaign.StartTimestamp.Unix()], func() {
-			a.campaignManager.Insert(campaignNode, campaign.TargetKeywords)
-		})
-		a.updateFunctions[campaign.EndTimestamp.Unix()] = append(a.updateFunctions[campaign.EndTimestamp.Unix()], func() {
-			a.DeleteCampaign(campaign.ImpressionURL)
-		})
-	} else if now.After(campaign.StartTimestamp) && now.Before(campaign.EndTimestamp) {
-		a.campaignManager.Insert(campaignNode, campaign.TargetKeywords)
-		a.updateFunctions[campaign.EndTimestamp.Unix()] = append(a.updateFunctions[campaign.EndTimestamp.Unix()], func() {
-			a.DeleteCampaign(campaign.ImpressionURL)
-		})
+	a.campaignIDToNode[campaign.ID] = campaignNode
+	a.targetings[campaign.ID] = t
+	lists, bids := t.lists()
+	if campaign.IsHouse() {
+		lists = append(lists, houseList)
 	}
+	campaignNode.Bids = bids
+	insert := func() {
+		a.campaignManager.Insert(campaignNode, lists)
+	}
+	switch {
+	case now.Before(campaign.StartTimestamp):
+		a.scheduleCampaignEvent(campaign.ID, campaign.StartTimestamp, insert)
+	case campaign.DailyCapReached(now):
+		// Served again once its daily caps reset.
+		a.scheduleCampaignEvent(campaign.ID, campaign.NextDayStart(now), insert)
+	default:
+		insert()
+	}
+	a.scheduleCampaignEvent(campaign.ID, campaign.EndTimestamp, func() {
+		a.deleteCampaign(campaign.ID)
+	})
+}
+
+// Schedules an update for a campaign that is cancelled if the campaign is
+// deleted or updated first.
+func (a *AdEngine) scheduleCampaignEvent(campaignID int, at time.Time, update func()) {
+	a.campaignEvents[campaignID] = append(a.campaignEvents[campaignID], a.scheduler.schedule(at, update))
+}
+
+// Replaces a registered campaign with a new version of it, re-positioning and
+// re-scheduling it according to the new version. This is also how the engine
+// reacts to a campaign changing status.
+func (a *AdEngine) UpdateCampaign(campaign *campaign.Campaign) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.deleteCampaign(campaign.ID)
+	a.registerCampaign(campaign)
 }
 
 // Returns the highest priority ad for the given keywords.
 func (a *AdEngine) RecommendCampaign(keywords []string) (*campaign.Campaign, bool) {
-	var bestCampaign *campaign.Campaign = nil
-	for _, keyword := range keywords {
-		campaign, ok := a.campaignManager.GetFirst(keyword)
-		if ok {
-			if bestCampaign == nil {
-				bestCampaign = campaign
-			} else if bestCampaign.Compare(campaign) > 0 {
-				bestCampaign = campaign
+	return a.RecommendCampaignFunc(keywords, func(*campaign.Campaign) bool { return true })
+}
+
+// Returns the highest priority ad for the given keywords that accept approves
+// of. Campaigns are offered to accept in priority order, each at most once,
+// until one is accepted.
+//
+// accept is called while the engine is locked for reading, so it must not call
+// back into the engine.
+func (a *AdEngine) RecommendCampaignFunc(keywords []string, accept func(*campaign.Campaign) bool) (*campaign.Campaign, bool) {
+	var recommended *campaign.Campaign
+	a.RangeCampaigns(keywords, nil, func(c Candidate) bool {
+		if accept(c.Campaign) {
+			recommended = c.Campaign
+			return false
+		}
+		return true
+	})
+	return recommended, recommended != nil
+}
+
+// A campaign that matches an ad decision request, at its highest bid for the
+// request's keywords. Its effective CPM is its bid weighted by its relevance to
+// the request, which is the share of the request's keyword weight it matches,
+// and its rank is what the engine's RankingPolicy makes of them.
+type Candidate struct {
+	Campaign  *campaign.Campaign
+	Bid       float64
+	Relevance float64
+	ECPM      float64
+	Rank      Rank
+}
+
+// Orders candidates by rank. Returns -1 when c comes first.
+func (c Candidate) compare(other Candidate) int {
+	return compareRanked(c.Rank, c.Campaign, other.Rank, other.Campaign)
+}
+
+// Calls visit with every campaign matching the given keywords, highest rank
+// first, each at most once, until visit returns false. Keywords weigh 1 unless
+// weights gives them a positive weight of their own. House campaigns that do
+// not match are visited last, as fill, bidding their CPM at no relevance.
+//
+// visit is called while the engine is locked for reading, so it must not call
+// back into the engine.
+func (a *AdEngine) RangeCampaigns(keywords []string, weights map[string]float64, visit func(Candidate) bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	q := newQuery(keywords, weights, a.normalizer)
+	// One cursor per keyword list, read in bid order.
+	var cursors []*cursor
+	for _, list := range q.lists() {
+		if n, ok := a.campaignManager.First(list); ok {
+			cursors = append(cursors, &cursor{keyword: list, node: n})
+		}
+	}
+	// Campaigns are ranked as the lists are read and visited once no campaign
+	// further down the lists can beat them. A campaign bids at most what it
+	// is listed at in the list of each keyword it matches, and ranks no higher
+	// for a request than in the list, so the best campaign left in the lists
+	// bounds the rank of every campaign that was not ranked yet.
+	scored := &candidateQueue{}
+	seen := make(map[int]bool)
+	matched := make(map[int]bool)
+	// Guaranteed campaigns are only served when the request's random draw
+	// falls into their share of the traffic.
+	draw := -1.0
+	for {
+		var next *cursor
+		for _, c := range cursors {
+			for c.node != nil && seen[c.node.Data.ID] {
+				c.node, _ = c.node.NextIn(c.keyword)
+			}
+			if c.node != nil && (next == nil || a.bound(c).compare(a.bound(next)) < 0) {
+				next = c
+			}
+		}
+		if scored.Len() > 0 && (next == nil || (*scored)[0].compare(a.bound(next)) < 0) {
+			if !visit(heap.Pop(scored).(Candidate)) {
+				return
+			}
+			continue
+		}
+		if next == nil {
+			break
+		}
+		// Campaigns listed under a word they do not match after all, or
+		// excluded by a negative keyword, are skipped.
+		c := next.node.Data
+		seen[c.ID] = true
+		if c.Tier == campaign.TierGuaranteed && a.plan != nil {
+			if draw < 0 {
+				draw = rand.Float64()
+			}
+			if !a.plan.serves(c.ID, q, draw) {
+				continue
 			}
 		}
+		if bid, relevance, ok := a.targetings[c.ID].match(q); ok {
+			matched[c.ID] = true
+			heap.Push(scored, Candidate{
+				Campaign:  c,
+				Bid:       bid,
+				Relevance: relevance,
+				ECPM:      bid * relevance,
+				Rank:      a.policy.RequestRank(c, bid, relevance),
+			})
+		}
 	}
-	if bestCampaign == nil {
-		return nil, false
+
+	// House campaigns fill what is left, in the order they are listed in.
+	for n, ok := a.campaignManager.First(houseList); ok; n, ok = n.NextIn(houseList) {
+		c := n.Data
+		if matched[c.ID] || a.targetings[c.ID].excludes(q) {
+			continue
+		}
+		if !visit(Candidate{Campaign: c, Bid: c.CPM, Rank: a.policy.RequestRank(c, c.CPM, 0)}) {
+			return
+		}
 	}
-	return bestCampaign, true
 }
 
-// Removes a campaign from being recommended.
-func (a *AdEngine) DeleteCampaign(impressionURL string) {
-	node := a.impressionURLToNode[impressionURL]
+// Max-heap of scored candidates.
+type candidateQueue []Candidate
+
+func (q candidateQueue) Len() int {
+	return len(q)
+}
+
+func (q candidateQueue) Less(i, j int) bool {
+	return q[i].compare(q[j]) < 0
+}
+
+func (q candidateQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *candidateQueue) Push(x any) {
+	*q = append(*q, x.(Candidate))
+}
+
+func (q *candidateQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// Position in the list of a keyword.
+type cursor struct {
+	keyword string
+	node    *ordered_multi_list.Node
+}
+
+// Returns the best candidate the campaigns from the cursor on could be: the
+// campaign at the cursor at its rank in the list. Campaigns further down the
+// list rank lower or come after it at the same rank.
+func (a *AdEngine) bound(c *cursor) Candidate {
+	bid := c.node.BidIn(c.keyword)
+	return Candidate{Campaign: c.node.Data, Bid: bid, Rank: a.policy.ListRank(c.node.Data, bid)}
+}
+
+// Removes a campaign from being recommended and cancels its pending updates.
+func (a *AdEngine) DeleteCampaign(campaignID int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.deleteCampaign(campaignID)
+}
+
+func (a *AdEngine) deleteCampaign(campaignID int) {
+	for _, id := range a.campaignEvents[campaignID] {
+		a.scheduler.cancel(id)
+	}
+	delete(a.campaignEvents, campaignID)
+	node, ok := a.campaignIDToNode[campaignID]
+	if !ok {
+		return
+	}
 	a.campaignManager.Delete(node)
-	delete(a.impressionURLToNode, impressionURL)
+	delete(a.campaignIDToNode, campaignID)
+	delete(a.targetings, campaignID)
 }
@@ -1,16 +1,17 @@
 package ad_engine
 
 import (
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/clock"
+	"github.com/kriscampos/adserver/internal/keyword"
 )
 
-// TODO: Refactor ad engine to inject a mock clock for testing.
-// Might just be able to use a mock ticker. need to investigate.
-
 func TestRegisterCampaign(t *testing.T) {
 	now := time.Now()
 	testcases := []struct {
@@ -29,7 +30,6 @@ func TestRegisterCampaign(t *testing.T) {
 					ImpressionCount: 0,
 					MaxImpression:   1,
 					CPM:             2.0,
-					ImpressionURL:   "ad0",
 				},
 			},
 			expected: &campaign.Campaign{
@@ -40,7 +40,6 @@ func TestRegisterCampaign(t *testing.T) {
 				ImpressionCount: 0,
 				MaxImpression:   1,
 				CPM:             2.0,
-				ImpressionURL:   "ad0",
 			},
 		},
 	}
@@ -85,7 +84,6 @@ func TestRecommendCampaign(t *testing.T) {
 					ImpressionCount: 0,
 					MaxImpression:   1,
 					CPM:             2.0,
-					ImpressionURL:   "ad0",
 				},
 			},
 			keywords: []string{"cat"},
@@ -97,7 +95,6 @@ func TestRecommendCampaign(t *testing.T) {
 				ImpressionCount: 0,
 				MaxImpression:   1,
 				CPM:             2.0,
-				ImpressionURL:   "ad0",
 			},
 		},
 		{
@@ -111,7 +108,6 @@ func TestRecommendCampaign(t *testing.T) {
 					ImpressionCount: 0,
 					MaxImpression:   1,
 					CPM:             2.0,
-					ImpressionURL:   "ad0",
 				},
 			},
 			keywords: []string{"cat"},
@@ -123,7 +119,6 @@ func TestRecommendCampaign(t *testing.T) {
 				ImpressionCount: 0,
 				MaxImpression:   1,
 				CPM:             2.0,
-				ImpressionURL:   "ad0",
 			},
 		},
 	}
@@ -146,3 +141,640 @@ func TestRecommendCampaign(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateCampaign(t *testing.T) {
+	now := time.Now()
+	campaigns := []*campaign.Campaign{
+		{
+			ID:             0,
+			StartTimestamp: now.Add(-time.Hour),
+			EndTimestamp:   now.Add(24 * time.Hour),
+			TargetKeywords: []string{"cat"},
+			MaxImpression:  1,
+			CPM:            2.0,
+		},
+		{
+			ID:             1,
+			StartTimestamp: now.Add(-time.Hour),
+			EndTimestamp:   now.Add(24 * time.Hour),
+			TargetKeywords: []string{"cat", "dog"},
+			MaxImpression:  1,
+			CPM:            3.0,
+		},
+	}
+	adEngine := NewAdEngine()
+	for _, c := range campaigns {
+		adEngine.RegisterCampaign(c)
+	}
+
+	// Drop campaign 1 below campaign 0 and stop targeting "dog".
+	updated := *campaigns[1]
+	updated.CPM = 1.0
+	updated.TargetKeywords = []string{"cat"}
+	adEngine.UpdateCampaign(&updated)
+
+	expected := map[string]int{"cat": 0}
+	for keyword, expectedID := range expected {
+		recommended, ok := adEngine.RecommendCampaign([]string{keyword})
+		if !ok || recommended.ID != expectedID {
+			t.Errorf("Expected campaign %d for %q but Found: %+v", expectedID, keyword, recommended)
+		}
+	}
+	if recommended, ok := adEngine.RecommendCampaign([]string{"dog"}); ok {
+		t.Errorf("Expected no recommendation for removed keyword but Found: %+v", recommended)
+	}
+
+	adEngine.DeleteCampaign(0)
+	if recommended, ok := adEngine.RecommendCampaign([]string{"cat"}); !ok || recommended != &updated {
+		t.Errorf("Expected updated campaign after deleting campaign 0 but Found: %+v", recommended)
+	}
+}
+
+func TestDeleteCampaign_Unknown(t *testing.T) {
+	adEngine := NewAdEngine()
+	adEngine.DeleteCampaign(7)
+	if _, ok := adEngine.RecommendCampaign([]string{"cat"}); ok {
+		t.Error("Expected no recommendation from an empty engine.")
+	}
+}
+
+func TestRegisterCampaign_NotLive(t *testing.T) {
+	now := time.Now()
+	adEngine := NewAdEngine()
+	for i, status := range []campaign.Status{campaign.StatusDraft, campaign.StatusPaused, campaign.StatusExhausted, campaign.StatusArchived} {
+		adEngine.RegisterCampaign(&campaign.Campaign{
+			ID:             i,
+			StartTimestamp: now.Add(-time.Hour),
+			EndTimestamp:   now.Add(time.Hour),
+			TargetKeywords: []string{"cat"},
+			CPM:            2.0,
+			Status:         status,
+		})
+	}
+	if recommended, ok := adEngine.RecommendCampaign([]string{"cat"}); ok {
+		t.Errorf("Expected campaigns that are not live to be ignored but Found: %+v", recommended)
+	}
+}
+
+func TestUpdateCampaign_Pause(t *testing.T) {
+	now := time.Now()
+	c := &campaign.Campaign{
+		ID:             0,
+		StartTimestamp: now.Add(-time.Hour),
+		EndTimestamp:   now.Add(time.Hour),
+		TargetKeywords: []string{"cat"},
+		CPM:            2.0,
+		Status:         campaign.StatusActive,
+	}
+	adEngine := NewAdEngine()
+	adEngine.RegisterCampaign(c)
+
+	paused := *c
+	paused.Status = campaign.StatusPaused
+	adEngine.UpdateCampaign(&paused)
+	if recommended, ok := adEngine.RecommendCampaign([]string{"cat"}); ok {
+		t.Errorf("Expected paused campaign to be removed but Found: %+v", recommended)
+	}
+
+	resumed := paused
+	resumed.Status = campaign.StatusActive
+	adEngine.UpdateCampaign(&resumed)
+	if _, ok := adEngine.RecommendCampaign([]string{"cat"}); !ok {
+		t.Error("Expected resumed campaign to be recommended.")
+	}
+}
+
+func TestRecommendCampaign_NormalizesKeywords(t *testing.T) {
+	now := time.Now()
+	normalizer := keyword.NewNormalizer(keyword.Config{CaseFold: true, Stem: true})
+	adEngine := NewAdEngine(WithNormalizer(normalizer))
+	adEngine.RegisterCampaign(&campaign.Campaign{
+		ID:             0,
+		StartTimestamp: now.Add(-time.Hour),
+		EndTimestamp:   now.Add(time.Hour),
+		TargetKeywords: []string{" Cats"},
+		CPM:            2.0,
+	})
+	for _, requested := range []string{"cat", "Cat ", "cats", "CATS"} {
+		if _, ok := adEngine.RecommendCampaign([]string{requested}); !ok {
+			t.Errorf("Expected %q to match campaign keyword \" Cats\".", requested)
+		}
+	}
+	if recommended, ok := adEngine.RecommendCampaign([]string{"dog"}); ok {
+		t.Errorf("Expected no recommendation for unrelated keyword but Found: %+v", recommended)
+	}
+}
+
+func TestRegisterCampaign_Schedule(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	fakeClock := clock.NewFake(start)
+	adEngine := NewAdEngine(WithClock(fakeClock))
+	upcoming := &campaign.Campaign{
+		ID:             0,
+		StartTimestamp: start.Add(10 * time.Second),
+		EndTimestamp:   start.Add(20 * time.Second),
+		TargetKeywords: []string{"cat"},
+		CPM:            2.0,
+	}
+	adEngine.RegisterCampaign(upcoming)
+
+	steps := []struct {
+		name     string
+		advance  time.Duration
+		expected bool
+	}{
+		{name: "Before start", advance: 9 * time.Second, expected: false},
+		{name: "At start", advance: time.Second, expected: true},
+		{name: "During flight", advance: 9 * time.Second, expected: true},
+		{name: "At end", advance: time.Second, expected: false},
+	}
+	for _, step := range steps {
+		fakeClock.Advance(step.advance)
+		adEngine.runUpdates(fakeClock.Now())
+		if _, ok := adEngine.RecommendCampaign([]string{"cat"}); ok != step.expected {
+			t.Errorf("%s: Expected recommendation %t but Found %t", step.name, step.expected, ok)
+		}
+	}
+}
+
+func TestRegisterCampaign_StartsNow(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)))
+	adEngine.RegisterCampaign(&campaign.Campaign{
+		ID:             0,
+		StartTimestamp: start,
+		EndTimestamp:   start.Add(time.Hour),
+		TargetKeywords: []string{"cat"},
+		CPM:            2.0,
+	})
+	if _, ok := adEngine.RecommendCampaign([]string{"cat"}); !ok {
+		t.Error("Expected campaign starting at the current time to be recommended.")
+	}
+}
+
+func TestRecommendCampaign_TieBreak(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)))
+	campaigns := []*campaign.Campaign{
+		{ID: 0, EndTimestamp: start.Add(3 * time.Hour)},
+		{ID: 1, EndTimestamp: start.Add(2 * time.Hour)},
+		{ID: 2, EndTimestamp: start.Add(2 * time.Hour)},
+	}
+	for _, c := range campaigns {
+		c.StartTimestamp = start
+		c.TargetKeywords = []string{"cat"}
+		c.CPM = 2.0
+		adEngine.RegisterCampaign(c)
+	}
+	// Equal CPMs favor the campaign ending soonest, then the lowest ID.
+	for _, expectedID := range []int{1, 2, 0} {
+		recommended, ok := adEngine.RecommendCampaign([]string{"cat"})
+		if !ok || recommended.ID != expectedID {
+			t.Fatalf("Expected campaign %d but Found: %+v", expectedID, recommended)
+		}
+		adEngine.DeleteCampaign(expectedID)
+	}
+}
+
+func TestRecommendCampaign_SameCampaignForSeveralKeywords(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)))
+	adEngine.RegisterCampaign(&campaign.Campaign{
+		ID:             0,
+		StartTimestamp: start,
+		EndTimestamp:   start.Add(time.Hour),
+		TargetKeywords: []string{"cat", "dog"},
+		CPM:            2.0,
+	})
+	if recommended, ok := adEngine.RecommendCampaign([]string{"cat", "dog"}); !ok || recommended.ID != 0 {
+		t.Errorf("Expected campaign 0 but Found: %+v", recommended)
+	}
+}
+
+func TestRecommendCampaignFunc(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)))
+	campaigns := []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat", "dog"}, CPM: 4.0},
+		{ID: 1, TargetKeywords: []string{"dog"}, CPM: 3.0},
+		{ID: 2, TargetKeywords: []string{"cat"}, CPM: 2.0},
+		{ID: 3, TargetKeywords: []string{"bird"}, CPM: 5.0},
+	}
+	for _, c := range campaigns {
+		c.StartTimestamp = start
+		c.EndTimestamp = start.Add(time.Hour)
+		adEngine.RegisterCampaign(c)
+	}
+
+	testcases := []struct {
+		name            string
+		acceptedID      int
+		expectedOffered []int
+		expectOK        bool
+	}{
+		{
+			name:            "Accept the best campaign",
+			acceptedID:      0,
+			expectedOffered: []int{0},
+			expectOK:        true,
+		},
+		{
+			name:            "Accept a campaign further down the lists",
+			acceptedID:      2,
+			expectedOffered: []int{0, 1, 2},
+			expectOK:        true,
+		},
+		{
+			name:            "Accept nothing",
+			acceptedID:      -1,
+			expectedOffered: []int{0, 1, 2},
+			expectOK:        false,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var offered []int
+			recommended, ok := adEngine.RecommendCampaignFunc([]string{"cat", "dog"}, func(c *campaign.Campaign) bool {
+				offered = append(offered, c.ID)
+				return c.ID == tc.acceptedID
+			})
+			if ok != tc.expectOK || ok && recommended.ID != tc.acceptedID {
+				t.Errorf("Expected campaign %d but Found: %+v", tc.acceptedID, recommended)
+			}
+			if diff := cmp.Diff(tc.expectedOffered, offered); diff != "" {
+				t.Errorf("Offered campaigns mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRegisterCampaign_DailyCapReached(t *testing.T) {
+	start := time.Date(2024, 3, 9, 14, 0, 0, 0, time.UTC)
+	fakeClock := clock.NewFake(start)
+	adEngine := NewAdEngine(WithClock(fakeClock))
+	c := &campaign.Campaign{
+		ID:                   0,
+		StartTimestamp:       start.Add(-time.Hour),
+		EndTimestamp:         start.Add(48 * time.Hour),
+		TargetKeywords:       []string{"cat"},
+		CPM:                  1.0,
+		DailyImpressionCap:   1,
+		DailyImpressionCount: 1,
+		Day:                  "2024-03-09",
+		Timezone:             "Asia/Tokyo",
+	}
+	adEngine.RegisterCampaign(c)
+	if recommended, ok := adEngine.RecommendCampaign([]string{"cat"}); ok {
+		t.Fatalf("Expected capped campaign to be held back but Found: %+v", recommended)
+	}
+
+	// Midnight in Tokyo.
+	fakeClock.Set(c.NextDayStart(start))
+	adEngine.runUpdates(fakeClock.Now())
+	if _, ok := adEngine.RecommendCampaign([]string{"cat"}); !ok {
+		t.Error("Expected campaign to be served again once its daily cap reset.")
+	}
+}
+
+func TestRunUpdates_CatchesUp(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	fakeClock := clock.NewFake(start)
+	adEngine := NewAdEngine(WithClock(fakeClock))
+	adEngine.RegisterCampaign(&campaign.Campaign{
+		ID:             0,
+		StartTimestamp: start.Add(10 * time.Second),
+		EndTimestamp:   start.Add(time.Hour),
+		TargetKeywords: []string{"cat"},
+		CPM:            2.0,
+	})
+	adEngine.RegisterCampaign(&campaign.Campaign{
+		ID:             1,
+		StartTimestamp: start.Add(5 * time.Second),
+		EndTimestamp:   start.Add(30 * time.Second),
+		TargetKeywords: []string{"dog"},
+		CPM:            2.0,
+	})
+
+	// A single late check both activates campaign 0 and runs campaign 1's
+	// whole flight.
+	fakeClock.Advance(time.Minute)
+	adEngine.runUpdates(fakeClock.Now())
+	if _, ok := adEngine.RecommendCampaign([]string{"cat"}); !ok {
+		t.Error("Expected activation that was skipped over to still happen.")
+	}
+	if recommended, ok := adEngine.RecommendCampaign([]string{"dog"}); ok {
+		t.Errorf("Expected expiry that was skipped over to still happen but Found: %+v", recommended)
+	}
+	if pending := adEngine.scheduler.pending(); pending != 1 {
+		t.Errorf("Expected only campaign 0's expiry to be pending but Found %d events", pending)
+	}
+}
+
+func TestDeleteCampaign_CancelsEvents(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	fakeClock := clock.NewFake(start)
+	adEngine := NewAdEngine(WithClock(fakeClock))
+	c := &campaign.Campaign{
+		ID:             0,
+		StartTimestamp: start.Add(time.Hour),
+		EndTimestamp:   start.Add(2 * time.Hour),
+		TargetKeywords: []string{"cat"},
+		CPM:            2.0,
+	}
+	adEngine.RegisterCampaign(c)
+
+	// Updating replaces the pending events rather than adding to them.
+	updated := *c
+	updated.StartTimestamp = start.Add(3 * time.Hour)
+	updated.EndTimestamp = start.Add(4 * time.Hour)
+	adEngine.UpdateCampaign(&updated)
+	if pending := adEngine.scheduler.pending(); pending != 2 {
+		t.Errorf("Expected 2 pending events after update but Found %d", pending)
+	}
+	fakeClock.Advance(90 * time.Minute)
+	adEngine.runUpdates(fakeClock.Now())
+	if recommended, ok := adEngine.RecommendCampaign([]string{"cat"}); ok {
+		t.Errorf("Expected old activation to be cancelled but Found: %+v", recommended)
+	}
+
+	adEngine.DeleteCampaign(0)
+	if pending := adEngine.scheduler.pending(); pending != 0 {
+		t.Errorf("Expected no pending events after delete but Found %d", pending)
+	}
+}
+
+func TestRunUpdates_StartedEngine(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	fakeClock := clock.NewFake(start)
+	adEngine := NewAdEngine(WithClock(fakeClock))
+	adEngine.Start()
+	defer adEngine.Stop()
+	adEngine.RegisterCampaign(&campaign.Campaign{
+		ID:             0,
+		StartTimestamp: start.Add(-time.Second),
+		EndTimestamp:   start.Add(time.Second),
+		TargetKeywords: []string{"cat"},
+		CPM:            2.0,
+	})
+	fakeClock.Advance(time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := adEngine.RecommendCampaign([]string{"cat"}); !ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the update goroutine to expire the campaign.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdEngine_Concurrent(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	fakeClock := clock.NewFake(start)
+	adEngine := NewAdEngine(WithClock(fakeClock))
+	keywords := []string{"cat", "dog", "bird"}
+	newCampaign := func(id int) *campaign.Campaign {
+		return &campaign.Campaign{
+			ID:             id,
+			StartTimestamp: start.Add(time.Duration(id%5) * time.Second),
+			EndTimestamp:   start.Add(time.Duration(10+id%7) * time.Second),
+			TargetKeywords: []string{keywords[id%3], keywords[(id+1)%3]},
+			CPM:            float64(id % 4),
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		w := w
+		wg.Add(3)
+		// Writers register, update and delete their own campaigns.
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				id := w*1000 + i
+				adEngine.RegisterCampaign(newCampaign(id))
+				if i%3 == 0 {
+					updated := newCampaign(id)
+					updated.CPM += 1.5
+					adEngine.UpdateCampaign(updated)
+				}
+				if i%4 == 0 {
+					adEngine.DeleteCampaign(id)
+				}
+			}
+		}()
+		// Readers ask for recommendations throughout.
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				adEngine.RecommendCampaign(keywords[i%3:])
+			}
+		}()
+		// Time moves on while campaigns are registered.
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				fakeClock.Advance(time.Second)
+				adEngine.runUpdates(fakeClock.Now())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every campaign has ended, so nothing may be left behind.
+	fakeClock.Advance(time.Minute)
+	adEngine.runUpdates(fakeClock.Now())
+	for _, keyword := range keywords {
+		if recommended, ok := adEngine.RecommendCampaign([]string{keyword}); ok {
+			t.Errorf("Expected every campaign to have expired but Found: %+v", recommended)
+		}
+	}
+	if pending := adEngine.scheduler.pending(); pending != 0 {
+		t.Errorf("Expected no pending events but Found %d", pending)
+	}
+}
+
+func TestRecommendCampaign_MatchTypesAndNegatives(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)))
+	campaigns := []*campaign.Campaign{
+		{
+			ID:               0,
+			TargetKeywords:   []string{"shoes", "boots"},
+			NegativeKeywords: []string{"kids"},
+			CPM:              5.0,
+		},
+		{
+			ID:                1,
+			TargetKeywords:    []string{"running shoes"},
+			KeywordMatchTypes: map[string]campaign.MatchType{"running shoes": campaign.MatchBroad},
+			CPM:               4.0,
+		},
+		{
+			ID:                2,
+			TargetKeywords:    []string{"shoes"},
+			KeywordMatchTypes: map[string]campaign.MatchType{"shoes": campaign.MatchPhrase},
+			CPM:               3.0,
+		},
+	}
+	for _, c := range campaigns {
+		c.StartTimestamp = start
+		c.EndTimestamp = start.Add(time.Hour)
+		adEngine.RegisterCampaign(c)
+	}
+
+	testcases := []struct {
+		name       string
+		keywords   []string
+		expectedID int
+	}{
+		{name: "Exact match wins", keywords: []string{"shoes"}, expectedID: 0},
+		{name: "Negative skips the head of every list", keywords: []string{"boots", "shoes", "kids"}, expectedID: 2},
+		{name: "Broad match", keywords: []string{"kids", "shoes for running"}, expectedID: 1},
+		{name: "Phrase match", keywords: []string{"kids shoes"}, expectedID: 2},
+		{name: "Nothing matches", keywords: []string{"kids boots"}, expectedID: -1},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			recommended, ok := adEngine.RecommendCampaign(tc.keywords)
+			actual := -1
+			if ok {
+				actual = recommended.ID
+			}
+			if actual != tc.expectedID {
+				t.Errorf("Expected campaign %d but Found %d", tc.expectedID, actual)
+			}
+		})
+	}
+}
+
+func TestRangeCampaigns_KeywordBids(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)))
+	campaigns := []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat", "dog"}, CPM: 1.0, KeywordBids: map[string]float64{"cat": 6.0}},
+		{ID: 1, TargetKeywords: []string{"cat", "dog"}, CPM: 3.0},
+		{
+			ID:                2,
+			TargetKeywords:    []string{"dog", "black cat"},
+			KeywordMatchTypes: map[string]campaign.MatchType{"black cat": campaign.MatchPhrase},
+			CPM:               2.0,
+			KeywordBids:       map[string]float64{"black cat": 8.0},
+		},
+	}
+	for _, c := range campaigns {
+		c.StartTimestamp = start
+		c.EndTimestamp = start.Add(time.Hour)
+		adEngine.RegisterCampaign(c)
+	}
+
+	testcases := []struct {
+		name     string
+		keywords []string
+		expected []Candidate
+	}{
+		{
+			name:     "Bid for the keyword",
+			keywords: []string{"cat"},
+			expected: []Candidate{
+				{Campaign: campaigns[0], Bid: 6.0, Relevance: 1, ECPM: 6.0},
+				{Campaign: campaigns[1], Bid: 3.0, Relevance: 1, ECPM: 3.0},
+			},
+		},
+		{
+			name:     "CPM without a bid for the keyword",
+			keywords: []string{"dog"},
+			expected: []Candidate{
+				{Campaign: campaigns[1], Bid: 3.0, Relevance: 1, ECPM: 3.0},
+				{Campaign: campaigns[2], Bid: 2.0, Relevance: 1, ECPM: 2.0},
+				{Campaign: campaigns[0], Bid: 1.0, Relevance: 1, ECPM: 1.0},
+			},
+		},
+		{
+			name:     "Highest bid of the matching keywords",
+			keywords: []string{"dog", "big black cat"},
+			expected: []Candidate{
+				{Campaign: campaigns[2], Bid: 8.0, Relevance: 1, ECPM: 8.0},
+				{Campaign: campaigns[1], Bid: 3.0, Relevance: 0.5, ECPM: 1.5},
+				{Campaign: campaigns[0], Bid: 1.0, Relevance: 0.5, ECPM: 0.5},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actual []Candidate
+			adEngine.RangeCampaigns(tc.keywords, nil, func(c Candidate) bool {
+				actual = append(actual, c)
+				return true
+			})
+			if diff := cmp.Diff(tc.expected, actual, cmpopts.IgnoreFields(Candidate{}, "Rank")); diff != "" {
+				t.Errorf("Candidates mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRangeCampaigns_Relevance(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)))
+	campaigns := []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat"}, CPM: 5.0},
+		{ID: 1, TargetKeywords: []string{"cat", "dog", "fish"}, CPM: 2.0},
+		{ID: 2, TargetKeywords: []string{"dog"}, CPM: 4.0},
+	}
+	for _, c := range campaigns {
+		c.StartTimestamp = start
+		c.EndTimestamp = start.Add(time.Hour)
+		adEngine.RegisterCampaign(c)
+	}
+
+	testcases := []struct {
+		name     string
+		keywords []string
+		weights  map[string]float64
+		expected []Candidate
+	}{
+		{
+			name:     "Campaign matching every keyword ranks above higher bids",
+			keywords: []string{"cat", "dog", "fish"},
+			expected: []Candidate{
+				{Campaign: campaigns[1], Bid: 2.0, Relevance: 1, ECPM: 2.0},
+				{Campaign: campaigns[0], Bid: 5.0, Relevance: 1.0 / 3, ECPM: 5.0 / 3},
+				{Campaign: campaigns[2], Bid: 4.0, Relevance: 1.0 / 3, ECPM: 4.0 / 3},
+			},
+		},
+		{
+			name:     "Weighted keyword",
+			keywords: []string{"cat", "dog", "fish"},
+			weights:  map[string]float64{"cat": 4},
+			expected: []Candidate{
+				{Campaign: campaigns[0], Bid: 5.0, Relevance: 4.0 / 6, ECPM: 20.0 / 6},
+				{Campaign: campaigns[1], Bid: 2.0, Relevance: 1, ECPM: 2.0},
+				{Campaign: campaigns[2], Bid: 4.0, Relevance: 1.0 / 6, ECPM: 4.0 / 6},
+			},
+		},
+		{
+			name:     "Duplicate keywords count once",
+			keywords: []string{"cat", "CAT", "dog"},
+			weights:  map[string]float64{"CAT": 3},
+			expected: []Candidate{
+				{Campaign: campaigns[0], Bid: 5.0, Relevance: 0.75, ECPM: 3.75},
+				{Campaign: campaigns[1], Bid: 2.0, Relevance: 1, ECPM: 2.0},
+				{Campaign: campaigns[2], Bid: 4.0, Relevance: 0.25, ECPM: 1.0},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actual []Candidate
+			adEngine.RangeCampaigns(tc.keywords, tc.weights, func(c Candidate) bool {
+				actual = append(actual, c)
+				return true
+			})
+			if diff := cmp.Diff(tc.expected, actual, cmpopts.EquateApprox(0, 1e-9), cmpopts.IgnoreFields(Candidate{}, "Rank")); diff != "" {
+				t.Errorf("Candidates mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package ad_engine
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kriscampos/adserver/internal/campaign"
+)
+
+// How often the allocation plan of guaranteed campaigns is recomputed by
+// default.
+const DefaultAllocationInterval = 5 * time.Minute
+
+// Forecasts how many ad decisions will be requested for a normalized keyword
+// between from and to. Keywords returns every keyword with a forecast, which
+// phrase and broad matched keywords are matched against.
+type SupplyForecaster interface {
+	Forecast(keyword string, from, to time.Time) float64
+	Keywords() []string
+}
+
+// Sets the forecast guaranteed campaigns are allocated traffic from. Without
+// one no plan is made and guaranteed campaigns are served whenever they win.
+func WithForecaster(forecaster SupplyForecaster) Option {
+	return func(a *AdEngine) {
+		a.forecaster = forecaster
+	}
+}
+
+// Sets how many impressions a campaign still needs to meet its goal. By
+// default this is worked out from the campaign as it was registered. It is
+// called while the engine is not locked, so it may block.
+func WithRemainingImpressions(remaining func(c *campaign.Campaign) int) Option {
+	return func(a *AdEngine) {
+		a.remaining = remaining
+	}
+}
+
+// Sets how often the allocation plan is recomputed.
+func WithAllocationInterval(interval time.Duration) Option {
+	return func(a *AdEngine) {
+		a.allocationInterval = interval
+	}
+}
+
+// How the forecast traffic is split between guaranteed campaigns, in the order
+// they were allocated in.
+type AllocationPlan struct {
+	ComputedAt time.Time            `json:"computed_at"`
+	Campaigns  []CampaignAllocation `json:"campaigns"`
+	byID       map[int]*CampaignAllocation
+}
+
+// What a guaranteed campaign is allocated. The campaign is served for a
+// request for one of its keywords only when a uniform random draw for the
+// request falls into the keyword's share. Each share starts where earlier
+// campaigns' shares of the keyword end and covers Probability of the keyword's
+// total traffic, or only what is left of it when less than that remains.
+type CampaignAllocation struct {
+	CampaignID int `json:"campaign_id"`
+	// Impressions the campaign still needs.
+	Demand int `json:"demand"`
+	// Forecast requests for the campaign's keywords over the rest of its
+	// flight.
+	Supply      float64                 `json:"supply"`
+	Probability float64                 `json:"probability"`
+	Keywords    map[string]KeywordShare `json:"keywords"`
+	// Impressions the campaign is expected to fall short of its goal by.
+	Shortfall float64 `json:"shortfall"`
+}
+
+// Range of a uniform random draw in [0, 1) a campaign is served for.
+type KeywordShare struct {
+	From float64 `json:"from"`
+	To   float64 `json:"to"`
+}
+
+func (s KeywordShare) contains(draw float64) bool {
+	return s.From <= draw && draw < s.To
+}
+
+// Returns the current allocation plan, which is nil until one was made.
+func (a *AdEngine) AllocationPlan() *AllocationPlan {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.plan
+}
+
+// A guaranteed campaign as it was registered when a plan was started.
+type guaranteedCampaign struct {
+	campaign *campaign.Campaign
+	targets  []target
+}
+
+// Recomputes the allocation plan. The registered guaranteed campaigns are read
+// while the engine is locked, but forecasts and remaining impressions are
+// worked out without holding the lock, so ad decisions are not held up.
+func (a *AdEngine) replan(now time.Time) {
+	a.mu.RLock()
+	var guaranteed []guaranteedCampaign
+	for id, node := range a.campaignIDToNode {
+		if node.Data.Tier == campaign.TierGuaranteed {
+			guaranteed = append(guaranteed, guaranteedCampaign{campaign: node.Data, targets: a.targetings[id].targets})
+		}
+	}
+	a.mu.RUnlock()
+
+	plan := a.allocate(guaranteed, now)
+	a.mu.Lock()
+	a.plan = plan
+	a.mu.Unlock()
+}
+
+// Allocates the forecast traffic to guaranteed campaigns with a high-water
+// mark algorithm. Campaigns with the least supply for their demand are
+// allocated first, and each takes the same share of what is left of every
+// keyword it matches, the smallest share that meets its demand.
+//
+// Supply is counted per request keyword the forecaster knows, so phrase and
+// broad matched campaigns are supplied by every keyword that contains their
+// phrase or words. Keywords are allocated independently of each other, and
+// requests only matching a broad matched campaign through several of their
+// keywords together do not count towards its supply.
+func (a *AdEngine) allocate(guaranteed []guaranteedCampaign, now time.Time) *AllocationPlan {
+	type contract struct {
+		allocation CampaignAllocation
+		supply     map[string]float64
+	}
+	var known [][]string
+	for _, k := range a.forecaster.Keywords() {
+		known = append(known, strings.Fields(k))
+	}
+	var contracts []*contract
+	for _, g := range guaranteed {
+		c := g.campaign
+		demand := a.remaining(c)
+		if demand <= 0 {
+			continue
+		}
+		from := now
+		if c.StartTimestamp.After(now) {
+			from = c.StartTimestamp
+		}
+		k := &contract{
+			allocation: CampaignAllocation{CampaignID: c.ID, Demand: demand},
+			supply:     make(map[string]float64),
+		}
+		for _, words := range known {
+			keyword := strings.Join(words, " ")
+			if _, ok := k.supply[keyword]; ok || !suppliedBy(g.targets, words) {
+				continue
+			}
+			k.supply[keyword] = a.forecaster.Forecast(keyword, from, c.EndTimestamp)
+			k.allocation.Supply += k.supply[keyword]
+		}
+		contracts = append(contracts, k)
+	}
+	sort.Slice(contracts, func(i, j int) bool {
+		x, y := contracts[i].allocation, contracts[j].allocation
+		ratio, otherRatio := x.Supply/float64(x.Demand), y.Supply/float64(y.Demand)
+		if ratio != otherRatio {
+			return ratio < otherRatio
+		}
+		return x.CampaignID < y.CampaignID
+	})
+
+	plan := &AllocationPlan{ComputedAt: now, Campaigns: []CampaignAllocation{}, byID: make(map[int]*CampaignAllocation)}
+	// Share of each keyword's traffic allocated so far.
+	used := make(map[string]float64)
+	for _, k := range contracts {
+		available := func(probability float64) float64 {
+			var total float64
+			for keyword, supply := range k.supply {
+				total += math.Min(1-used[keyword], probability) * supply
+			}
+			return total
+		}
+		demand := float64(k.allocation.Demand)
+		probability := 1.0
+		if most := available(1); most <= demand {
+			k.allocation.Shortfall = demand - most
+		} else {
+			low := 0.0
+			for i := 0; i < 50; i++ {
+				mid := (low + probability) / 2
+				if available(mid) >= demand {
+					probability = mid
+				} else {
+					low = mid
+				}
+			}
+		}
+		k.allocation.Probability = probability
+		k.allocation.Keywords = make(map[string]KeywordShare)
+		for keyword := range k.supply {
+			share := KeywordShare{From: used[keyword]}
+			share.To = share.From + math.Min(1-share.From, probability)
+			used[keyword] = share.To
+			k.allocation.Keywords[keyword] = share
+		}
+		plan.Campaigns = append(plan.Campaigns, k.allocation)
+	}
+	for i := range plan.Campaigns {
+		plan.byID[plan.Campaigns[i].CampaignID] = &plan.Campaigns[i]
+	}
+	return plan
+}
+
+// Determines if requests for a keyword match one of the targets on their own.
+func suppliedBy(targets []target, words []string) bool {
+	for _, t := range targets {
+		switch t.matchType {
+		case campaign.MatchPhrase:
+			if containsPhrase(words, t.words) {
+				return true
+			}
+		case campaign.MatchBroad:
+			if containsWords(words, t.words) {
+				return true
+			}
+		default:
+			if len(words) == len(t.words) && equalWords(words, t.words) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Determines if words contains every one of want in any order.
+func containsWords(words, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, word := range words {
+			if word == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Remaining impressions of a campaign as it was registered.
+func registeredRemaining(c *campaign.Campaign) int {
+	return c.MaxImpression - c.ImpressionCount
+}
+
+// Decides if a guaranteed campaign is served for a request according to the
+// allocation plan. Campaigns are served for a keyword of the request they
+// have a share of when the request's draw falls into it, or otherwise with the
+// campaign's probability. Campaigns the plan does not cover are always served.
+func (p *AllocationPlan) serves(campaignID int, q *query, draw float64) bool {
+	allocation, ok := p.byID[campaignID]
+	if !ok {
+		return true
+	}
+	shared := false
+	for _, k := range q.keywords {
+		if share, ok := allocation.Keywords[strings.Join(k.words, " ")]; ok {
+			if share.contains(draw) {
+				return true
+			}
+			shared = true
+		}
+	}
+	return !shared && draw < allocation.Probability
+}
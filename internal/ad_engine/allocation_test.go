@@ -0,0 +1,203 @@
+package ad_engine
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/clock"
+)
+
+// Forecasts a constant number of requests per hour for each keyword.
+type fakeForecaster map[string]float64
+
+func (f fakeForecaster) Forecast(keyword string, from, to time.Time) float64 {
+	return f[keyword] * to.Sub(from).Hours()
+}
+
+func (f fakeForecaster) Keywords() []string {
+	var keywords []string
+	for k := range f {
+		keywords = append(keywords, k)
+	}
+	return keywords
+}
+
+func newAllocatedEngine(fakeClock *clock.Fake, forecaster fakeForecaster, campaigns []*campaign.Campaign) *AdEngine {
+	adEngine := NewAdEngine(
+		WithClock(fakeClock),
+		WithForecaster(forecaster),
+		WithAllocationInterval(time.Minute),
+	)
+	for _, c := range campaigns {
+		c.StartTimestamp = fakeClock.Now()
+		c.EndTimestamp = fakeClock.Now().Add(10 * time.Hour)
+		adEngine.RegisterCampaign(c)
+	}
+	adEngine.replan(fakeClock.Now())
+	return adEngine
+}
+
+func TestAllocationPlan(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	fakeClock := clock.NewFake(start)
+	adEngine := newAllocatedEngine(fakeClock, fakeForecaster{"cat": 100, "dog": 50}, []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat"}, MaxImpression: 300, Tier: campaign.TierGuaranteed},
+		{ID: 1, TargetKeywords: []string{"cat", "dog"}, MaxImpression: 600, Tier: campaign.TierGuaranteed},
+		{ID: 2, TargetKeywords: []string{"fish"}, MaxImpression: 10, Tier: campaign.TierGuaranteed},
+		{ID: 3, TargetKeywords: []string{"cat"}, MaxImpression: 5, ImpressionCount: 5, Tier: campaign.TierGuaranteed},
+		{ID: 4, TargetKeywords: []string{"cat"}, MaxImpression: 100},
+	})
+
+	expected := &AllocationPlan{
+		ComputedAt: start,
+		Campaigns: []CampaignAllocation{
+			{
+				CampaignID:  2,
+				Demand:      10,
+				Probability: 1,
+				Keywords:    map[string]KeywordShare{},
+				Shortfall:   10,
+			},
+			{
+				CampaignID:  1,
+				Demand:      600,
+				Supply:      1500,
+				Probability: 0.4,
+				Keywords: map[string]KeywordShare{
+					"cat": {From: 0, To: 0.4},
+					"dog": {From: 0, To: 0.4},
+				},
+			},
+			{
+				CampaignID:  0,
+				Demand:      300,
+				Supply:      1000,
+				Probability: 0.3,
+				Keywords:    map[string]KeywordShare{"cat": {From: 0.4, To: 0.7}},
+			},
+		},
+	}
+	opts := cmp.Options{cmpopts.IgnoreUnexported(AllocationPlan{}), cmpopts.EquateApprox(0, 1e-9)}
+	if diff := cmp.Diff(expected, adEngine.AllocationPlan(), opts); diff != "" {
+		t.Errorf("Plan mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAllocationPlan_PhraseAndBroad(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	forecaster := fakeForecaster{"cat": 100, "black cat": 20, "cat food": 10, "food for cat": 5, "dog": 50}
+	adEngine := newAllocatedEngine(clock.NewFake(start), forecaster, []*campaign.Campaign{
+		{
+			ID:                0,
+			TargetKeywords:    []string{"black cat"},
+			KeywordMatchTypes: map[string]campaign.MatchType{"black cat": campaign.MatchPhrase},
+			MaxImpression:     100,
+			Tier:              campaign.TierGuaranteed,
+		},
+		{
+			ID:                1,
+			TargetKeywords:    []string{"cat food"},
+			KeywordMatchTypes: map[string]campaign.MatchType{"cat food": campaign.MatchBroad},
+			MaxImpression:     75,
+			Tier:              campaign.TierGuaranteed,
+		},
+	})
+
+	expected := []CampaignAllocation{
+		{
+			CampaignID:  0,
+			Demand:      100,
+			Supply:      200,
+			Probability: 0.5,
+			Keywords:    map[string]KeywordShare{"black cat": {From: 0, To: 0.5}},
+		},
+		{
+			CampaignID:  1,
+			Demand:      75,
+			Supply:      150,
+			Probability: 0.5,
+			Keywords: map[string]KeywordShare{
+				"cat food":     {From: 0, To: 0.5},
+				"food for cat": {From: 0, To: 0.5},
+			},
+		},
+	}
+	if diff := cmp.Diff(expected, adEngine.AllocationPlan().Campaigns, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Errorf("Plan mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAllocationPlan_Start(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	fakeClock := clock.NewFake(start)
+	adEngine := NewAdEngine(WithClock(fakeClock), WithForecaster(fakeForecaster{}), WithAllocationInterval(time.Minute))
+	adEngine.Start()
+	defer adEngine.Stop()
+
+	// Waits for the update goroutine to plan at the given time.
+	awaitPlan := func(at time.Time) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if plan := adEngine.AllocationPlan(); plan != nil && plan.ComputedAt.Equal(at) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected a plan computed at %v.", at)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	fakeClock.Advance(time.Second)
+	awaitPlan(start.Add(time.Second))
+	fakeClock.Advance(time.Minute)
+	awaitPlan(start.Add(time.Minute + time.Second))
+}
+
+func TestAllocationPlan_NoForecaster(t *testing.T) {
+	adEngine := NewAdEngine(WithClock(clock.NewFake(time.Unix(1684616602, 0))))
+	adEngine.Start()
+	defer adEngine.Stop()
+	if plan := adEngine.AllocationPlan(); plan != nil {
+		t.Errorf("Expected no plan but Found %+v", plan)
+	}
+}
+
+func TestRangeCampaigns_Allocation(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := newAllocatedEngine(clock.NewFake(start), fakeForecaster{"cat": 100, "dog": 50}, []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat"}, MaxImpression: 300, CPM: 2.0, Tier: campaign.TierGuaranteed},
+		{ID: 1, TargetKeywords: []string{"cat", "dog"}, MaxImpression: 600, CPM: 1.0, Tier: campaign.TierGuaranteed},
+		{ID: 2, TargetKeywords: []string{"cat"}, MaxImpression: 100, CPM: 5.0},
+	})
+
+	testcases := []struct {
+		name     string
+		keywords []string
+		expected map[int]float64
+	}{
+		{name: "Shared keyword", keywords: []string{"cat"}, expected: map[int]float64{0: 0.3, 1: 0.4, 2: 0.3}},
+		{name: "Keyword of one campaign", keywords: []string{"dog"}, expected: map[int]float64{1: 0.4, -1: 0.6}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			const requests = 10000
+			wins := make(map[int]int)
+			for i := 0; i < requests; i++ {
+				id := -1
+				if c, ok := adEngine.RecommendCampaign(tc.keywords); ok {
+					id = c.ID
+				}
+				wins[id]++
+			}
+			for id, expected := range tc.expected {
+				if share := float64(wins[id]) / requests; math.Abs(share-expected) > 0.03 {
+					t.Errorf("Expected campaign %d to win about %.0f%% of requests but Found %.1f%%", id, expected*100, share*100)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package ad_engine
+
+import (
+	"math"
+
+	"github.com/kriscampos/adserver/internal/campaign"
+)
+
+const (
+	// CPM a winner pays above the runner-up by default.
+	DefaultBidIncrement = 0.01
+	// Lowest CPM a campaign can win at by default, which is also what winners
+	// without a runner-up pay.
+	DefaultFloorCPM = 0.10
+)
+
+// Sets the lowest CPM a campaign can win at. Campaigns bidding less are never
+// served, except for house campaigns. Winners without a runner-up pay the
+// floor, so it should be positive for them to pay anything.
+func WithFloorCPM(floorCPM float64) Option {
+	return func(a *AdEngine) {
+		a.floorCPM = floorCPM
+	}
+}
+
+// Sets the CPM a winner pays above the runner-up.
+func WithBidIncrement(increment float64) Option {
+	return func(a *AdEngine) {
+		a.bidIncrement = increment
+	}
+}
+
+// What an auction is run for.
+type AuctionRequest struct {
+	Keywords []string
+	// Relevance weights of keywords. Keywords weigh 1 by default.
+	KeywordWeights map[string]float64
+	// Number of ads to place. Auctions place one ad when it is not positive.
+	Slots int
+	// Forbids placing two ads of the same advertiser. Campaigns without an
+	// advertiser never conflict.
+	DistinctAdvertisers bool
+}
+
+// A campaign placed by an auction at its bid and the CPM it pays.
+type Placement struct {
+	Campaign    *campaign.Campaign
+	Bid         float64
+	ClearingCPM float64
+}
+
+// Outcome of a generalized second-price auction. Placements are in rank order
+// and RunnerUp is the best eligible campaign that was not placed, if any.
+type AuctionResult struct {
+	Placements []Placement
+	RunnerUp   *Candidate
+}
+
+// Runs a generalized second-price auction between the campaigns for the given
+// keywords. The slots are filled in rank order with distinct house campaigns or
+// campaigns bidding at or above the floor that reserve accepts, and the runner-up is the next
+// one that is eligible. Every placed campaign pays the least it could have bid
+// to rank above the campaign ranked below it, plus the bid increment, but no
+// less than the floor and no more than its own bid. Ranked by effective CPM,
+// that is the effective CPM of the campaign below divided by its relevance.
+//
+// reserve and eligible are called while the engine is locked for reading, so
+// they must not call back into the engine.
+func (a *AdEngine) RunAuction(request AuctionRequest, reserve, eligible func(Candidate) bool) (*AuctionResult, bool) {
+	slots := request.Slots
+	if slots < 1 {
+		slots = 1
+	}
+	var (
+		winners     []Candidate
+		runnerUp    *Candidate
+		advertisers = make(map[string]bool)
+	)
+	a.RangeCampaigns(request.Keywords, request.KeywordWeights, func(c Candidate) bool {
+		switch {
+		case c.Bid < a.floorCPM && !c.Campaign.IsHouse():
+			return true
+		case request.DistinctAdvertisers && c.Campaign.Advertiser != "" && advertisers[c.Campaign.Advertiser]:
+			return true
+		case len(winners) < slots:
+			if reserve(c) {
+				winners = append(winners, c)
+				advertisers[c.Campaign.Advertiser] = true
+			}
+			return true
+		case eligible(c):
+			runnerUp = &c
+			return false
+		}
+		return true
+	})
+	if len(winners) == 0 {
+		return nil, false
+	}
+
+	result := &AuctionResult{RunnerUp: runnerUp}
+	for i, winner := range winners {
+		next := runnerUp
+		if i+1 < len(winners) {
+			next = &winners[i+1]
+		}
+		result.Placements = append(result.Placements, Placement{
+			Campaign:    winner.Campaign,
+			Bid:         winner.Bid,
+			ClearingCPM: a.clearingCPM(winner, next),
+		})
+	}
+	return result, true
+}
+
+func (a *AdEngine) clearingCPM(winner Candidate, next *Candidate) float64 {
+	price := a.floorCPM
+	// House campaigns filling a request they do not match pay the floor.
+	if next != nil && winner.Relevance > 0 {
+		price = math.Max(price, a.policy.MinBid(winner, *next)+a.bidIncrement)
+	}
+	return math.Min(price, winner.Bid)
+}
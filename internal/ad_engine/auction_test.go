@@ -0,0 +1,218 @@
+package ad_engine
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/clock"
+)
+
+func TestRunAuction(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	campaigns := []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat"}, CPM: 5.0},
+		{ID: 1, TargetKeywords: []string{"dog"}, CPM: 3.0},
+		{ID: 2, TargetKeywords: []string{"cat"}, CPM: 2.0},
+		{ID: 3, TargetKeywords: []string{"dog"}, CPM: 0.5},
+	}
+	all := func(Candidate) bool { return true }
+	except := func(ids ...int) func(Candidate) bool {
+		return func(c Candidate) bool {
+			for _, id := range ids {
+				if c.Campaign.ID == id {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	testcases := []struct {
+		name             string
+		opts             []Option
+		keywords         []string
+		reserve          func(Candidate) bool
+		eligible         func(Candidate) bool
+		expectedWinner   int
+		expectedRunnerUp int
+		expectedCPM      float64
+	}{
+		{
+			name:             "Winner pays runner-up plus increment",
+			keywords:         []string{"cat", "dog"},
+			reserve:          all,
+			eligible:         all,
+			expectedWinner:   0,
+			expectedRunnerUp: 1,
+			expectedCPM:      3.01,
+		},
+		{
+			name:             "Ineligible runner-up is skipped",
+			keywords:         []string{"cat", "dog"},
+			reserve:          all,
+			eligible:         except(1),
+			expectedWinner:   0,
+			expectedRunnerUp: 2,
+			expectedCPM:      2.01,
+		},
+		{
+			name:             "Unreserved campaign does not win",
+			keywords:         []string{"cat", "dog"},
+			reserve:          except(0),
+			eligible:         all,
+			expectedWinner:   1,
+			expectedRunnerUp: 2,
+			expectedCPM:      2.01,
+		},
+		{
+			name:             "Lone winner pays the floor",
+			opts:             []Option{WithFloorCPM(1.0)},
+			keywords:         []string{"cat"},
+			reserve:          except(2),
+			eligible:         except(2),
+			expectedWinner:   0,
+			expectedRunnerUp: -1,
+			expectedCPM:      1.0,
+		},
+		{
+			name:             "Floor above runner-up",
+			opts:             []Option{WithFloorCPM(4.0)},
+			keywords:         []string{"cat", "dog"},
+			reserve:          all,
+			eligible:         all,
+			expectedWinner:   0,
+			expectedRunnerUp: -1,
+			expectedCPM:      4.0,
+		},
+		{
+			name:             "Winner never pays more than its CPM",
+			opts:             []Option{WithBidIncrement(0.5)},
+			keywords:         []string{"dog", "cat"},
+			reserve:          except(0),
+			eligible:         all,
+			expectedWinner:   1,
+			expectedRunnerUp: 2,
+			expectedCPM:      2.5,
+		},
+		{
+			name:           "Campaigns below the floor never win",
+			opts:           []Option{WithFloorCPM(1.0)},
+			keywords:       []string{"dog"},
+			reserve:        except(1),
+			eligible:       all,
+			expectedWinner: -1,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			adEngine := NewAdEngine(append([]Option{WithClock(clock.NewFake(start))}, tc.opts...)...)
+			for _, c := range campaigns {
+				c.StartTimestamp = start
+				c.EndTimestamp = start.Add(time.Hour)
+				adEngine.RegisterCampaign(c)
+			}
+			result, ok := adEngine.RunAuction(AuctionRequest{Keywords: tc.keywords}, tc.reserve, tc.eligible)
+			if tc.expectedWinner < 0 {
+				if ok {
+					t.Errorf("Expected no winner but Found: %+v", result.Placements)
+				}
+				return
+			}
+			if !ok || len(result.Placements) != 1 {
+				t.Fatalf("Expected campaign %d to win alone but Found: %+v", tc.expectedWinner, result)
+			}
+			winner := result.Placements[0]
+			if winner.Campaign.ID != tc.expectedWinner {
+				t.Errorf("Expected campaign %d to win but Found %d", tc.expectedWinner, winner.Campaign.ID)
+			}
+			runnerUp := -1
+			if result.RunnerUp != nil {
+				runnerUp = result.RunnerUp.Campaign.ID
+			}
+			if runnerUp != tc.expectedRunnerUp {
+				t.Errorf("Expected runner-up %d but Found %d", tc.expectedRunnerUp, runnerUp)
+			}
+			if math.Abs(winner.ClearingCPM-tc.expectedCPM) > 1e-9 {
+				t.Errorf("Expected clearing CPM %v but Found %v", tc.expectedCPM, winner.ClearingCPM)
+			}
+		})
+	}
+}
+
+func TestRunAuction_Slots(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)), WithFloorCPM(0.5))
+	campaigns := []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat"}, CPM: 5.0, Advertiser: "acme"},
+		{ID: 1, TargetKeywords: []string{"dog"}, CPM: 4.0, Advertiser: "acme"},
+		{ID: 2, TargetKeywords: []string{"cat", "dog"}, CPM: 3.0, Advertiser: "globex"},
+		{ID: 3, TargetKeywords: []string{"dog"}, CPM: 2.0},
+		{ID: 4, TargetKeywords: []string{"cat"}, CPM: 1.0},
+	}
+	for _, c := range campaigns {
+		c.StartTimestamp = start
+		c.EndTimestamp = start.Add(time.Hour)
+		adEngine.RegisterCampaign(c)
+	}
+	all := func(Candidate) bool { return true }
+
+	testcases := []struct {
+		name             string
+		request          AuctionRequest
+		expected         []Placement
+		expectedRunnerUp int
+	}{
+		{
+			name:    "Each position pays for the one below it",
+			request: AuctionRequest{Keywords: []string{"cat", "dog"}, Slots: 3},
+			expected: []Placement{
+				{Campaign: campaigns[2], Bid: campaigns[2].CPM, ClearingCPM: 2.51},
+				{Campaign: campaigns[0], Bid: campaigns[0].CPM, ClearingCPM: 4.01},
+				{Campaign: campaigns[1], Bid: campaigns[1].CPM, ClearingCPM: 2.01},
+			},
+			expectedRunnerUp: 3,
+		},
+		{
+			name:    "Distinct advertisers",
+			request: AuctionRequest{Keywords: []string{"cat", "dog"}, Slots: 3, DistinctAdvertisers: true},
+			expected: []Placement{
+				{Campaign: campaigns[2], Bid: campaigns[2].CPM, ClearingCPM: 2.51},
+				{Campaign: campaigns[0], Bid: campaigns[0].CPM, ClearingCPM: 2.01},
+				{Campaign: campaigns[3], Bid: campaigns[3].CPM, ClearingCPM: 1.01},
+			},
+			expectedRunnerUp: 4,
+		},
+		{
+			name:    "More slots than campaigns",
+			request: AuctionRequest{Keywords: []string{"dog"}, Slots: 5},
+			expected: []Placement{
+				{Campaign: campaigns[1], Bid: campaigns[1].CPM, ClearingCPM: 3.01},
+				{Campaign: campaigns[2], Bid: campaigns[2].CPM, ClearingCPM: 2.01},
+				{Campaign: campaigns[3], Bid: campaigns[3].CPM, ClearingCPM: 0.5},
+			},
+			expectedRunnerUp: -1,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, ok := adEngine.RunAuction(tc.request, all, all)
+			if !ok {
+				t.Fatal("Expected placements but Found none.")
+			}
+			if diff := cmp.Diff(tc.expected, result.Placements, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+				t.Errorf("Placements mismatch (-want +got):\n%s", diff)
+			}
+			runnerUp := -1
+			if result.RunnerUp != nil {
+				runnerUp = result.RunnerUp.Campaign.ID
+			}
+			if runnerUp != tc.expectedRunnerUp {
+				t.Errorf("Expected runner-up %d but Found %d", tc.expectedRunnerUp, runnerUp)
+			}
+		})
+	}
+}
@@ -6,6 +6,9 @@ type Node struct {
 	Data *campaign.Campaign
 	Next map[string]*Node
 	Prev map[string]*Node
+	// Bid of the node in each list, which lists are ordered by. Lists without
+	// a bid use the campaign's CPM.
+	Bids map[string]float64
 }
 
 func NewNode(data *campaign.Campaign) *Node {
@@ -16,6 +19,20 @@ func NewNode(data *campaign.Campaign) *Node {
 	}
 }
 
+// Returns the bid n is ordered by in a list.
+func (n *Node) BidIn(listName string) float64 {
+	if bid, ok := n.Bids[listName]; ok {
+		return bid
+	}
+	return n.Data.CPM
+}
+
+// Returns the Node following n in a list.
+func (n *Node) NextIn(listName string) (*Node, bool) {
+	next, ok := n.Next[listName]
+	return next, ok && next != nil
+}
+
 func (n *Node) initReferences(keywords []string) {
 	for _, keyword := range keywords {
 		n.Next[keyword] = nil
@@ -20,7 +20,7 @@ func TestNewNode(t *testing.T) {
 
 func TestInitReferences(t *testing.T) {
 	errorMsg := "%s is missing from %s"
-	n := NewNode(&campaign.Campaign{ID: 1, CPM: 100.0, ImpressionURL: "test"})
+	n := NewNode(&campaign.Campaign{ID: 1, CPM: 100.0})
 	keywords := []string{"cat", "dog"}
 	n.initReferences(keywords)
 	for _, keyword := range keywords {
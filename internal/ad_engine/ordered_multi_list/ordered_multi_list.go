@@ -15,15 +15,59 @@ import "github.com/kriscampos/adserver/internal/campaign"
 //	3.) Once an element is found in one list, we know where it is in every list
 //		it belongs to.
 type OrderedMultiList struct {
-	lists map[string]*Node
+	lists    map[string]*Node
+	ordering Ordering
 }
 
-func NewOrderedMultiList() *OrderedMultiList {
-	return &OrderedMultiList{
-		lists: make(map[string]*Node),
+// Orders the nodes of a list. Compare returns -1 when n comes before other in
+// the list, and may only return 0 for the same node.
+//
+// Orderings must not depend on anything that changes while nodes are listed,
+// such as the time, as nodes are only positioned when they are inserted and
+// lists would silently fall out of order.
+type Ordering interface {
+	Compare(n, other *Node, listName string) int
+}
+
+// Configures optional behavior of an OrderedMultiList.
+type Option func(*OrderedMultiList)
+
+// Sets how lists are ordered. Lists are ordered by bid by default.
+func WithOrdering(ordering Ordering) Option {
+	return func(o *OrderedMultiList) {
+		o.ordering = ordering
 	}
 }
 
+func NewOrderedMultiList(opts ...Option) *OrderedMultiList {
+	o := &OrderedMultiList{
+		lists:    make(map[string]*Node),
+		ordering: byBid{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Orders nodes by their bid in a list, breaking ties by campaign ID.
+type byBid struct{}
+
+func (byBid) Compare(n, other *Node, listName string) int {
+	bid, otherBid := n.BidIn(listName), other.BidIn(listName)
+	switch {
+	case bid > otherBid:
+		return -1
+	case bid < otherBid:
+		return 1
+	case n.Data.ID < other.Data.ID:
+		return -1
+	case n.Data.ID > other.Data.ID:
+		return 1
+	}
+	return 0
+}
+
 func (o *OrderedMultiList) GetFirst(listName string) (*campaign.Campaign, bool) {
 	n, ok := o.lists[listName]
 	if !ok {
@@ -32,36 +76,25 @@ func (o *OrderedMultiList) GetFirst(listName string) (*campaign.Campaign, bool)
 	return n.Data, ok
 }
 
-// Inserts Node into lists.
+// Returns the Node at the head of a list.
+func (o *OrderedMultiList) First(listName string) (*Node, bool) {
+	n, ok := o.lists[listName]
+	return n, ok
+}
+
+// Inserts Node into lists. Nodes can be ordered differently in each list, e.g.
+// by their bid in it, so the insertion point is found in each list separately.
 func (o *OrderedMultiList) Insert(n *Node, listNames []string) {
 	listNames = append(listNames, "")
-	remainingNamesToPrev := map[string]*Node{}
 	for _, listName := range listNames {
-		if inserted := o.insertAtHead(n, listName); !inserted {
-			remainingNamesToPrev[listName] = nil
-		}
-	}
-	if len(remainingNamesToPrev) > 0 {
-		// find insertion point and collect prev references
-		var prev *Node = nil
-		current, i, ok := o.lists[""], 0, true
-		for ok && current.Data.Compare(n.Data) < 0 {
-			prev = current
-			current, ok = current.Next[""]
-			i++
-			for name := range remainingNamesToPrev {
-				if namePrev, ok := prev.Prev[name]; ok {
-					remainingNamesToPrev[name] = namePrev
-				}
-			}
+		if inserted := o.insertAtHead(n, listName); inserted {
+			continue
 		}
-		remainingNamesToPrev[""] = prev
-		for name, prev := range remainingNamesToPrev {
-			if prev == nil {
-				prev = o.lists[name]
-			}
-			o.insertAfterNode(n, prev, name)
+		prev := o.lists[listName]
+		for next, ok := prev.NextIn(listName); ok && o.ordering.Compare(next, n, listName) < 0; next, ok = next.NextIn(listName) {
+			prev = next
 		}
+		o.insertAfterNode(n, prev, listName)
 	}
 }
 
@@ -72,7 +105,7 @@ func (o *OrderedMultiList) insertAtHead(n *Node, listName string) bool {
 		o.lists[listName] = n
 		delete(n.Next, listName)
 		return true
-	} else if n.Data.Compare(head.Data) < 0 { // insert at 0th index
+	} else if o.ordering.Compare(n, head, listName) < 0 { // insert at 0th index
 		n.Next[listName] = head
 		head.Prev[listName] = n
 		o.lists[listName] = n
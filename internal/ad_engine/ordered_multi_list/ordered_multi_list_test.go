@@ -437,6 +437,51 @@ func TestInsert_MultiList_InsertSeveralLists(t *testing.T) {
 	}
 }
 
+func TestInsert_MultiList_AfterSharedNode(t *testing.T) {
+	lists := NewOrderedMultiList()
+	nodes := []*Node{
+		NewNode(&campaign.Campaign{
+			ID:           1,
+			CPM:          5.0,
+			EndTimestamp: time.Now().Add(24 * time.Hour),
+		}),
+		NewNode(&campaign.Campaign{
+			ID:           2,
+			CPM:          4.8,
+			EndTimestamp: time.Now().Add(24 * time.Hour),
+		}),
+		NewNode(&campaign.Campaign{
+			ID:           3,
+			CPM:          4.0,
+			EndTimestamp: time.Now().Add(24 * time.Hour),
+		}),
+		NewNode(&campaign.Campaign{
+			ID:           4,
+			CPM:          4.6,
+			EndTimestamp: time.Now().Add(24 * time.Hour),
+		}),
+	}
+	listNames := [][]string{
+		{"dog"},
+		{"dog"},
+		{"dog"},
+		{"dog"},
+	}
+	for i, Node := range nodes {
+		lists.Insert(Node, listNames[i])
+	}
+	expected := map[string][]int{
+		"dog": {1, 2, 4, 3},
+		"":    {1, 2, 4, 3},
+	}
+	for listName := range lists.lists {
+		actual := lists.getList(listName)
+		if equals := cmp.Equal(expected[listName], actual); !equals {
+			t.Errorf("Expected: %+v Found: %+v", expected[listName], actual)
+		}
+	}
+}
+
 func TestDelete_EmptyList(t *testing.T) {
 	lists := NewOrderedMultiList()
 	n := NewNode(&campaign.Campaign{
@@ -627,3 +672,52 @@ func TestDelete_NonMemberNode(t *testing.T) {
 		t.Errorf("Expected: %+v Found: %+v", expected, actual)
 	}
 }
+
+func TestInsert_MultiList_PerListBids(t *testing.T) {
+	lists := NewOrderedMultiList()
+	end := time.Now().Add(24 * time.Hour)
+	withBids := func(id int, cpm float64, bids map[string]float64) *Node {
+		n := NewNode(&campaign.Campaign{ID: id, CPM: cpm, EndTimestamp: end})
+		n.Bids = bids
+		return n
+	}
+	nodes := []*Node{
+		withBids(1, 1.0, map[string]float64{"cat": 6.0}),
+		withBids(2, 3.0, nil),
+		withBids(3, 2.0, map[string]float64{"dog": 4.0, "cat": 0.5}),
+		withBids(4, 5.0, map[string]float64{"dog": 1.0}),
+	}
+	for _, n := range nodes {
+		lists.Insert(n, []string{"cat", "dog"})
+	}
+	expected := map[string][]int{
+		"cat": {1, 4, 2, 3},
+		"dog": {3, 2, 1, 4},
+		"":    {4, 2, 3, 1},
+	}
+	for listName := range lists.lists {
+		actual := lists.getList(listName)
+		if equals := cmp.Equal(expected[listName], actual); !equals {
+			t.Errorf("Expected %s: %+v Found: %+v", listName, expected[listName], actual)
+		}
+	}
+}
+
+// Orders nodes by ascending ID in every list.
+type byID struct{}
+
+func (byID) Compare(n, other *Node, listName string) int {
+	return n.Data.ID - other.Data.ID
+}
+
+func TestInsert_WithOrdering(t *testing.T) {
+	lists := NewOrderedMultiList(WithOrdering(byID{}))
+	for _, id := range []int{3, 1, 4, 2} {
+		lists.Insert(NewNode(&campaign.Campaign{ID: id, CPM: float64(id)}), []string{"cat"})
+	}
+	expected := []int{1, 2, 3, 4}
+	actual := lists.getList("cat")
+	if equals := cmp.Equal(expected, actual); !equals {
+		t.Errorf("Expected: %+v Found: %+v", expected, actual)
+	}
+}
@@ -0,0 +1,203 @@
+package ad_engine
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/kriscampos/adserver/internal/ad_engine/ordered_multi_list"
+	"github.com/kriscampos/adserver/internal/campaign"
+)
+
+// Where a campaign ranks. Higher tiers always come first, and higher scores
+// come first within a tier.
+type Rank struct {
+	Tier  int
+	Score float64
+}
+
+// Returns -1 when r ranks above other, 0 when they are equal, and 1 when r ranks
+// below other.
+func (r Rank) compare(other Rank) int {
+	switch {
+	case r.Tier > other.Tier:
+		return -1
+	case r.Tier < other.Tier:
+		return 1
+	case r.Score > other.Score:
+		return -1
+	case r.Score < other.Score:
+		return 1
+	}
+	return 0
+}
+
+// RankingPolicy decides the order campaigns are recommended and auctioned in.
+//
+// Keyword lists are ordered by ListRank when campaigns are inserted, and read
+// only as far as needed to find the campaigns with the highest RequestRank. For
+// that to work, neither rank may depend on the time or anything else that
+// changes while a campaign is registered, both must never decrease as the bid
+// grows, and RequestRank must never exceed ListRank for the same bid.
+//
+// Campaigns with the same rank are ordered by their end time, sooner first,
+// and then by ID.
+type RankingPolicy interface {
+	// Ranks a campaign in a keyword list given its bid in the list.
+	ListRank(c *campaign.Campaign, bid float64) Rank
+	// Ranks a campaign for a request given its bid for the request and its
+	// relevance to it, from 0 to 1.
+	RequestRank(c *campaign.Campaign, bid, relevance float64) Rank
+	// Returns the least winner could have bid for the request and still
+	// ranked above next, which second-price auctions charge.
+	MinBid(winner, next Candidate) float64
+}
+
+// Sets how campaigns are ranked. Campaigns are ranked by their priority tier and
+// then by effective CPM by default.
+func WithRankingPolicy(policy RankingPolicy) Option {
+	return func(a *AdEngine) {
+		a.policy = policy
+	}
+}
+
+// Returns a built-in policy by name: "cpm", "ecpm" or "random". Built-in
+// policies rank campaigns by their priority tier first.
+func ParseRankingPolicy(name string) (RankingPolicy, error) {
+	switch name {
+	case "cpm":
+		return ByTier(CPMPolicy{}), nil
+	case "ecpm":
+		return ByTier(ECPMPolicy{}), nil
+	case "random":
+		return ByTier(NewRandomPolicy(time.Now().UnixNano())), nil
+	}
+	return nil, fmt.Errorf("unknown ranking policy %q", name)
+}
+
+// Ranks campaigns by their bid, regardless of relevance.
+type CPMPolicy struct{}
+
+func (CPMPolicy) ListRank(c *campaign.Campaign, bid float64) Rank {
+	return Rank{Score: bid}
+}
+
+func (CPMPolicy) RequestRank(c *campaign.Campaign, bid, relevance float64) Rank {
+	return Rank{Score: bid}
+}
+
+func (CPMPolicy) MinBid(winner, next Candidate) float64 {
+	return next.Bid
+}
+
+// Ranks campaigns by their effective CPM, their bid weighted by their
+// relevance to the request.
+type ECPMPolicy struct{}
+
+func (ECPMPolicy) ListRank(c *campaign.Campaign, bid float64) Rank {
+	return Rank{Score: bid}
+}
+
+func (ECPMPolicy) RequestRank(c *campaign.Campaign, bid, relevance float64) Rank {
+	return Rank{Score: bid * relevance}
+}
+
+func (ECPMPolicy) MinBid(winner, next Candidate) float64 {
+	return next.ECPM / winner.Relevance
+}
+
+// Ranks campaigns by the tier Tier puts them in, and campaigns in the same
+// tier by Within. Campaigns in a higher tier outrank lower tiers at any bid.
+type TierPolicy struct {
+	Tier   func(*campaign.Campaign) int
+	Within RankingPolicy
+}
+
+// Ranks campaigns by their priority tier, and campaigns in the same tier by
+// within.
+func ByTier(within RankingPolicy) TierPolicy {
+	return TierPolicy{Tier: (*campaign.Campaign).TierRank, Within: within}
+}
+
+func (p TierPolicy) ListRank(c *campaign.Campaign, bid float64) Rank {
+	return Rank{Tier: p.Tier(c), Score: p.Within.ListRank(c, bid).Score}
+}
+
+func (p TierPolicy) RequestRank(c *campaign.Campaign, bid, relevance float64) Rank {
+	return Rank{Tier: p.Tier(c), Score: p.Within.RequestRank(c, bid, relevance).Score}
+}
+
+// Winners outranking the next campaign's tier only pay the floor.
+func (p TierPolicy) MinBid(winner, next Candidate) float64 {
+	if winner.Rank.Tier > next.Rank.Tier {
+		return 0
+	}
+	return p.Within.MinBid(winner, next)
+}
+
+// Ranks campaigns randomly, so each request goes to a campaign with a
+// probability proportional to its effective CPM. Since any campaign can come
+// first, keyword lists are read in full for every request.
+type RandomPolicy struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewRandomPolicy(seed int64) *RandomPolicy {
+	return &RandomPolicy{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Every campaign is listed at the highest score a request can give it.
+func (p *RandomPolicy) ListRank(c *campaign.Campaign, bid float64) Rank {
+	return Rank{Score: 1}
+}
+
+// Scores campaigns with u^(1/w) for a uniformly random u and the effective CPM
+// w, which orders them as a weighted sample without replacement.
+func (p *RandomPolicy) RequestRank(c *campaign.Campaign, bid, relevance float64) Rank {
+	weight := bid * relevance
+	if weight <= 0 {
+		return Rank{}
+	}
+	p.mu.Lock()
+	u := p.rand.Float64()
+	p.mu.Unlock()
+	return Rank{Score: math.Pow(u, 1/weight)}
+}
+
+func (p *RandomPolicy) MinBid(winner, next Candidate) float64 {
+	return next.ECPM / winner.Relevance
+}
+
+// Orders campaigns by rank, then by end time, sooner first, and then by ID.
+// Returns 0 only for the same campaign.
+func compareRanked(rank Rank, c *campaign.Campaign, otherRank Rank, other *campaign.Campaign) int {
+	if cmp := rank.compare(otherRank); cmp != 0 {
+		return cmp
+	}
+	switch {
+	case c.ID == other.ID:
+		return 0
+	case c.EndTimestamp.Before(other.EndTimestamp):
+		return -1
+	case c.EndTimestamp.After(other.EndTimestamp):
+		return 1
+	case c.ID < other.ID:
+		return -1
+	}
+	return 1
+}
+
+// Orders keyword lists by the ListRank of a policy.
+type listOrdering struct {
+	policy RankingPolicy
+}
+
+func (o listOrdering) Compare(n, other *ordered_multi_list.Node, listName string) int {
+	return compareRanked(
+		o.policy.ListRank(n.Data, n.BidIn(listName)), n.Data,
+		o.policy.ListRank(other.Data, other.BidIn(listName)), other.Data,
+	)
+}
@@ -0,0 +1,201 @@
+package ad_engine
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/clock"
+)
+
+func TestCompareRanked(t *testing.T) {
+	end := time.Unix(1684616602, 0)
+	testcases := []struct {
+		name      string
+		rank      Rank
+		input     *campaign.Campaign
+		otherRank Rank
+		other     *campaign.Campaign
+		expected  int
+	}{
+		{
+			name:      "Higher tier",
+			rank:      Rank{Tier: 1, Score: 1.0},
+			input:     &campaign.Campaign{ID: 1, EndTimestamp: end},
+			otherRank: Rank{Score: 10.0},
+			other:     &campaign.Campaign{ID: 2, EndTimestamp: end},
+			expected:  -1,
+		},
+		{
+			name:      "Lower score",
+			rank:      Rank{Score: 9.0},
+			input:     &campaign.Campaign{ID: 1, EndTimestamp: end},
+			otherRank: Rank{Score: 10.0},
+			other:     &campaign.Campaign{ID: 2, EndTimestamp: end},
+			expected:  1,
+		},
+		{
+			name:      "Ends sooner",
+			rank:      Rank{Score: 10.0},
+			input:     &campaign.Campaign{ID: 2, EndTimestamp: end},
+			otherRank: Rank{Score: 10.0},
+			other:     &campaign.Campaign{ID: 1, EndTimestamp: end.Add(time.Hour)},
+			expected:  -1,
+		},
+		{
+			name:      "Higher ID",
+			rank:      Rank{Score: 10.0},
+			input:     &campaign.Campaign{ID: 3, EndTimestamp: end},
+			otherRank: Rank{Score: 10.0},
+			other:     &campaign.Campaign{ID: 2, EndTimestamp: end},
+			expected:  1,
+		},
+		{
+			name:      "Same campaign",
+			rank:      Rank{Score: 10.0},
+			input:     &campaign.Campaign{ID: 2, EndTimestamp: end},
+			otherRank: Rank{Score: 10.0},
+			other:     &campaign.Campaign{ID: 2, EndTimestamp: end},
+			expected:  0,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := compareRanked(tc.rank, tc.input, tc.otherRank, tc.other); actual != tc.expected {
+				t.Errorf("Expected %d but Found %d", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRankingPolicies(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	campaigns := []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat"}, CPM: 5.0},
+		{ID: 1, TargetKeywords: []string{"cat", "dog"}, CPM: 3.0},
+		{ID: 2, TargetKeywords: []string{"dog"}, CPM: 4.0},
+	}
+	tier := func(c *campaign.Campaign) int {
+		if c.ID == 2 {
+			return 1
+		}
+		return 0
+	}
+
+	testcases := []struct {
+		name        string
+		policy      RankingPolicy
+		expected    []int
+		expectedCPM float64
+	}{
+		{name: "CPM", policy: CPMPolicy{}, expected: []int{0, 2, 1}, expectedCPM: 4.01},
+		{name: "eCPM", policy: ECPMPolicy{}, expected: []int{1, 0, 2}, expectedCPM: 2.51},
+		{name: "Tier then CPM", policy: TierPolicy{Tier: tier, Within: CPMPolicy{}}, expected: []int{2, 0, 1}, expectedCPM: 0.1},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			adEngine := NewAdEngine(WithClock(clock.NewFake(start)), WithRankingPolicy(tc.policy))
+			for _, c := range campaigns {
+				c.StartTimestamp = start
+				c.EndTimestamp = start.Add(time.Hour)
+				adEngine.RegisterCampaign(c)
+			}
+			keywords := []string{"cat", "dog"}
+			var actual []int
+			adEngine.RangeCampaigns(keywords, nil, func(c Candidate) bool {
+				actual = append(actual, c.Campaign.ID)
+				return true
+			})
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("Order mismatch (-want +got):\n%s", diff)
+			}
+
+			all := func(Candidate) bool { return true }
+			result, ok := adEngine.RunAuction(AuctionRequest{Keywords: keywords}, all, all)
+			if !ok {
+				t.Fatal("Expected a winner but Found none.")
+			}
+			if actual := result.Placements[0].ClearingCPM; math.Abs(actual-tc.expectedCPM) > 1e-9 {
+				t.Errorf("Expected clearing CPM %v but Found %v", tc.expectedCPM, actual)
+			}
+		})
+	}
+}
+
+func TestRandomPolicy(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)), WithRankingPolicy(NewRandomPolicy(1)))
+	for _, c := range []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat"}, CPM: 3.0},
+		{ID: 1, TargetKeywords: []string{"cat"}, CPM: 1.0},
+	} {
+		c.StartTimestamp = start
+		c.EndTimestamp = start.Add(time.Hour)
+		adEngine.RegisterCampaign(c)
+	}
+
+	const requests = 10000
+	wins := make(map[int]int)
+	for i := 0; i < requests; i++ {
+		c, ok := adEngine.RecommendCampaign([]string{"cat"})
+		if !ok {
+			t.Fatal("Expected a campaign but Found none.")
+		}
+		wins[c.ID]++
+	}
+	if share := float64(wins[0]) / requests; math.Abs(share-0.75) > 0.03 {
+		t.Errorf("Expected campaign 0 to win about 75%% of requests but Found %.1f%%", share*100)
+	}
+}
+
+func TestParseRankingPolicy(t *testing.T) {
+	for _, name := range []string{"cpm", "ecpm", "random"} {
+		if _, err := ParseRankingPolicy(name); err != nil {
+			t.Errorf("Unexpected error for %q: %v", name, err)
+		}
+	}
+	if _, err := ParseRankingPolicy("cheapest"); err == nil {
+		t.Error("Expected an error for an unknown policy.")
+	}
+}
+
+func TestRangeCampaigns_Tiers(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	adEngine := NewAdEngine(WithClock(clock.NewFake(start)))
+	for _, c := range []*campaign.Campaign{
+		{ID: 0, TargetKeywords: []string{"cat"}, CPM: 5.0},
+		{ID: 1, TargetKeywords: []string{"cat"}, CPM: 0.5, Tier: campaign.TierSponsorship},
+		{ID: 2, TargetKeywords: []string{"cat"}, CPM: 1.0, Tier: campaign.TierGuaranteed},
+		{ID: 3, TargetKeywords: []string{"promo"}, CPM: 9.0, Tier: campaign.TierHouse, NegativeKeywords: []string{"free"}},
+		{ID: 4, TargetKeywords: []string{"cat"}, CPM: 0.1, Tier: campaign.TierHouse},
+		{ID: 5, TargetKeywords: []string{"\x00house"}, CPM: 9.0},
+	} {
+		c.StartTimestamp = start
+		c.EndTimestamp = start.Add(time.Hour)
+		adEngine.RegisterCampaign(c)
+	}
+
+	testcases := []struct {
+		name     string
+		keywords []string
+		expected []int
+	}{
+		{name: "Higher tiers first, then house fill", keywords: []string{"cat"}, expected: []int{1, 2, 0, 4, 3}},
+		{name: "House fill only", keywords: []string{"dog"}, expected: []int{3, 4}},
+		{name: "House fill excluded by a negative keyword", keywords: []string{"dog", "free"}, expected: []int{4}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actual []int
+			adEngine.RangeCampaigns(tc.keywords, nil, func(c Candidate) bool {
+				actual = append(actual, c.Campaign.ID)
+				return true
+			})
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("Order mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package ad_engine
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Identifies a scheduled event so it can be cancelled.
+type eventID uint64
+
+type event struct {
+	id    eventID
+	at    time.Time
+	run   func()
+	index int
+}
+
+// Min-heap of events ordered by time, then by the order they were scheduled in.
+type eventQueue []*event
+
+func (q eventQueue) Len() int {
+	return len(q)
+}
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].id < q[j].id
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x any) {
+	e := x.(*event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// Runs functions once their time has come.
+//
+// Rather than looking for events at exactly the current time, every event at
+// or before the current time is run, so events are never missed when the
+// scheduler is checked late or irregularly.
+type scheduler struct {
+	queue  eventQueue
+	events map[eventID]*event
+	nextID eventID
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{events: make(map[eventID]*event)}
+}
+
+// Schedules a function to run at the given time.
+func (s *scheduler) schedule(at time.Time, run func()) eventID {
+	s.nextID++
+	e := &event{id: s.nextID, at: at, run: run}
+	heap.Push(&s.queue, e)
+	s.events[e.id] = e
+	return e.id
+}
+
+// Prevents an event from running. Returns false if it already ran or was cancelled.
+func (s *scheduler) cancel(id eventID) bool {
+	e, ok := s.events[id]
+	if !ok {
+		return false
+	}
+	heap.Remove(&s.queue, e.index)
+	delete(s.events, id)
+	return true
+}
+
+// Runs every event scheduled at or before now in time order and returns how
+// many ran. Events may schedule or cancel other events while running.
+func (s *scheduler) runDue(now time.Time) int {
+	ran := 0
+	for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+		e := heap.Pop(&s.queue).(*event)
+		delete(s.events, e.id)
+		e.run()
+		ran++
+	}
+	return ran
+}
+
+// Returns the number of events waiting to run.
+func (s *scheduler) pending() int {
+	return len(s.queue)
+}
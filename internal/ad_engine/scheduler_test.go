@@ -0,0 +1,79 @@
+package ad_engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScheduler_RunDue(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	s := newScheduler()
+	ran := []int{}
+	record := func(i int) func() {
+		return func() { ran = append(ran, i) }
+	}
+	s.schedule(start.Add(3*time.Second), record(3))
+	s.schedule(start.Add(time.Second), record(1))
+	s.schedule(start.Add(2*time.Second), record(2))
+	s.schedule(start.Add(time.Second), record(4))
+	s.schedule(start.Add(time.Hour), record(5))
+
+	// Checking late runs everything that was missed, in order.
+	if count := s.runDue(start.Add(10 * time.Second)); count != 4 {
+		t.Errorf("Expected 4 events to run but Found %d", count)
+	}
+	if expected := []int{1, 4, 2, 3}; !cmp.Equal(expected, ran) {
+		t.Errorf("Expected: %+v Found: %+v", expected, ran)
+	}
+	if s.pending() != 1 || len(s.events) != 1 {
+		t.Errorf("Expected executed events to be removed but Found %d queued and %d indexed", s.pending(), len(s.events))
+	}
+	if count := s.runDue(start.Add(10 * time.Second)); count != 0 {
+		t.Errorf("Expected events to run only once but %d ran again", count)
+	}
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	s := newScheduler()
+	ran := []int{}
+	ids := []eventID{}
+	for i := 0; i < 4; i++ {
+		i := i
+		ids = append(ids, s.schedule(start.Add(time.Duration(i)*time.Second), func() { ran = append(ran, i) }))
+	}
+	if !s.cancel(ids[2]) {
+		t.Error("Expected pending event to be cancelled.")
+	}
+	if s.cancel(ids[2]) {
+		t.Error("Expected cancelling twice to fail.")
+	}
+	s.runDue(start.Add(time.Minute))
+	if expected := []int{0, 1, 3}; !cmp.Equal(expected, ran) {
+		t.Errorf("Expected: %+v Found: %+v", expected, ran)
+	}
+	if s.cancel(ids[0]) {
+		t.Error("Expected cancelling an event that ran to fail.")
+	}
+}
+
+func TestScheduler_ScheduleWhileRunning(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	s := newScheduler()
+	ran := []string{}
+	var later eventID
+	s.schedule(start, func() {
+		ran = append(ran, "first")
+		s.schedule(start, func() { ran = append(ran, "due") })
+		s.schedule(start.Add(time.Hour), func() { ran = append(ran, "not due") })
+		s.cancel(later)
+	})
+	later = s.schedule(start.Add(time.Second), func() { ran = append(ran, "cancelled") })
+
+	s.runDue(start.Add(time.Minute))
+	if expected := []string{"first", "due"}; !cmp.Equal(expected, ran) {
+		t.Errorf("Expected: %+v Found: %+v", expected, ran)
+	}
+}
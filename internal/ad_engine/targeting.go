@@ -0,0 +1,243 @@
+package ad_engine
+
+import (
+	"math"
+	"strings"
+
+	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/keyword"
+)
+
+// How a campaign is matched against ad decision requests, with its keywords
+// normalized and split into words.
+type targeting struct {
+	targets   []target
+	negatives [][]string
+}
+
+type target struct {
+	words     []string
+	matchType campaign.MatchType
+	bid       float64
+}
+
+func newTargeting(c *campaign.Campaign, normalizer *keyword.Normalizer) *targeting {
+	t := &targeting{}
+	for _, k := range c.TargetKeywords {
+		if normalized := normalizer.Normalize(k); normalized != "" {
+			t.targets = append(t.targets, target{
+				words:     strings.Fields(normalized),
+				matchType: c.MatchTypeOf(k),
+				bid:       c.BidFor(k),
+			})
+		}
+	}
+	for _, k := range normalizer.NormalizeAll(c.NegativeKeywords) {
+		t.negatives = append(t.negatives, strings.Fields(k))
+	}
+	return t
+}
+
+// Returns the lists the campaign is inserted in along with its bid in each.
+// Exactly matched keywords have a list of their own, while phrase and broad
+// matched keywords are listed under their first word, at the highest bid of
+// the keywords sharing it, and checked against the request when they come up.
+func (t *targeting) lists() ([]string, map[string]float64) {
+	var lists []string
+	bids := make(map[string]float64)
+	for _, target := range t.targets {
+		list := strings.Join(target.words, " ")
+		if target.matchType != campaign.MatchExact {
+			list = wordList(target.words[0])
+		}
+		bid, seen := bids[list]
+		if !seen {
+			lists = append(lists, list)
+		}
+		if !seen || target.bid > bid {
+			bids[list] = target.bid
+		}
+	}
+	return lists, bids
+}
+
+// Returns the highest bid of the campaign's keywords that match the request,
+// and how relevant the campaign is to the request: the share of the request's
+// keyword weight that its matching keywords cover, from 0 to 1. Campaigns
+// match when one of their keywords does and none of their negative keywords
+// do.
+func (t *targeting) match(q *query) (bid, relevance float64, ok bool) {
+	if t.excludes(q) {
+		return 0, 0, false
+	}
+	var matching []target
+	for _, target := range t.targets {
+		if !target.matches(q) {
+			continue
+		}
+		if len(matching) == 0 || target.bid > bid {
+			bid = target.bid
+		}
+		matching = append(matching, target)
+	}
+	if len(matching) == 0 {
+		return 0, 0, false
+	}
+	var covered, total float64
+	for _, k := range q.keywords {
+		total += k.weight
+		for _, target := range matching {
+			if target.covers(k.words) {
+				covered += k.weight
+				break
+			}
+		}
+	}
+	return bid, covered / total, true
+}
+
+// Determines if one of the campaign's negative keywords is in the request.
+func (t *targeting) excludes(q *query) bool {
+	for _, negative := range t.negatives {
+		if q.hasPhrase(negative) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t target) matches(q *query) bool {
+	switch t.matchType {
+	case campaign.MatchPhrase:
+		return q.hasPhrase(t.words)
+	case campaign.MatchBroad:
+		return q.hasWords(t.words)
+	default:
+		return q.exact[strings.Join(t.words, " ")]
+	}
+}
+
+// Determines if a request keyword counts towards the relevance of a matching
+// target. Broad matched targets cover every keyword sharing a word with them.
+func (t target) covers(words []string) bool {
+	switch t.matchType {
+	case campaign.MatchPhrase:
+		return containsPhrase(words, t.words)
+	case campaign.MatchBroad:
+		for _, word := range t.words {
+			for _, w := range words {
+				if w == word {
+					return true
+				}
+			}
+		}
+		return false
+	default:
+		return len(words) == len(t.words) && equalWords(words, t.words)
+	}
+}
+
+// The normalized keywords of an ad decision request.
+type query struct {
+	keywords []queryKeyword
+	exact    map[string]bool
+	words    map[string]bool
+}
+
+type queryKeyword struct {
+	words  []string
+	weight float64
+}
+
+// Normalizes the keywords of a request. Keywords weigh 1 unless weights gives
+// them a positive weight of their own, and keywords that normalize the same
+// are counted once at the highest of their weights.
+func newQuery(keywords []string, weights map[string]float64, normalizer *keyword.Normalizer) *query {
+	q := &query{exact: make(map[string]bool), words: make(map[string]bool)}
+	index := make(map[string]int)
+	for _, k := range keywords {
+		normalized := normalizer.Normalize(k)
+		if normalized == "" {
+			continue
+		}
+		weight, ok := weights[k]
+		if !ok || weight <= 0 {
+			weight = 1
+		}
+		if i, seen := index[normalized]; seen {
+			q.keywords[i].weight = math.Max(q.keywords[i].weight, weight)
+			continue
+		}
+		index[normalized] = len(q.keywords)
+		q.exact[normalized] = true
+		words := strings.Fields(normalized)
+		q.keywords = append(q.keywords, queryKeyword{words: words, weight: weight})
+		for _, word := range words {
+			q.words[word] = true
+		}
+	}
+	return q
+}
+
+// Returns the lists campaigns matching the request can be found in.
+func (q *query) lists() []string {
+	var lists []string
+	for _, k := range q.keywords {
+		lists = append(lists, strings.Join(k.words, " "))
+	}
+	for word := range q.words {
+		lists = append(lists, wordList(word))
+	}
+	return lists
+}
+
+// Determines if one of the request's keywords contains the words in order and
+// next to each other.
+func (q *query) hasPhrase(phrase []string) bool {
+	for _, k := range q.keywords {
+		if containsPhrase(k.words, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// Determines if the request contains all of the words.
+func (q *query) hasWords(words []string) bool {
+	for _, word := range words {
+		if !q.words[word] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsPhrase(words, phrase []string) bool {
+	for start := 0; start+len(phrase) <= len(words); start++ {
+		if equalWords(words[start:start+len(phrase)], phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalWords(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the list phrase and broad matched keywords starting with the word
+// are inserted in. Normalized keywords never start with a space, so these
+// lists cannot clash with the lists of exactly matched keywords.
+func wordList(word string) string {
+	return " " + word
+}
+
+// List every house campaign is inserted in, so they can fill requests they do
+// not match. The normalizer treats control characters as whitespace, so no
+// normalized keyword or word can clash with it.
+const houseList = "\x00house"
@@ -0,0 +1,47 @@
+package ad_engine
+
+import (
+	"testing"
+
+	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/keyword"
+)
+
+func TestTargetingMatches(t *testing.T) {
+	normalizer := keyword.NewNormalizer(keyword.DefaultConfig())
+	withMatchType := func(matchType campaign.MatchType) *campaign.Campaign {
+		return &campaign.Campaign{
+			TargetKeywords:    []string{"Running Shoes"},
+			KeywordMatchTypes: map[string]campaign.MatchType{"Running Shoes": matchType},
+			NegativeKeywords:  []string{"free", "used shoes"},
+		}
+	}
+	exact, phrase, broad := withMatchType(campaign.MatchExact), withMatchType(campaign.MatchPhrase), withMatchType(campaign.MatchBroad)
+
+	testcases := []struct {
+		name     string
+		input    *campaign.Campaign
+		keywords []string
+		expected bool
+	}{
+		{name: "Exact", input: exact, keywords: []string{"running shoes"}, expected: true},
+		{name: "Exact within a longer keyword", input: exact, keywords: []string{"red running shoes"}, expected: false},
+		{name: "Phrase within a longer keyword", input: phrase, keywords: []string{"red running shoes"}, expected: true},
+		{name: "Phrase out of order", input: phrase, keywords: []string{"shoes for running"}, expected: false},
+		{name: "Phrase across keywords", input: phrase, keywords: []string{"running", "shoes"}, expected: false},
+		{name: "Broad out of order", input: broad, keywords: []string{"shoes for running"}, expected: true},
+		{name: "Broad across keywords", input: broad, keywords: []string{"running", "shoes"}, expected: true},
+		{name: "Broad missing a word", input: broad, keywords: []string{"running"}, expected: false},
+		{name: "Negative keyword", input: exact, keywords: []string{"running shoes", "free"}, expected: false},
+		{name: "Negative phrase within a keyword", input: broad, keywords: []string{"running", "cheap used shoes"}, expected: false},
+		{name: "Negative phrase words apart", input: broad, keywords: []string{"used running shoes"}, expected: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, actual := newTargeting(tc.input, normalizer).match(newQuery(tc.keywords, nil, normalizer))
+			if actual != tc.expected {
+				t.Errorf("Expected %t but Found %t", tc.expected, actual)
+			}
+		})
+	}
+}
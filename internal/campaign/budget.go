@@ -0,0 +1,37 @@
+package campaign
+
+import "time"
+
+// Margin allowed when comparing amounts of money, so rounding errors from
+// adding up impression prices do not make a budget look overspent.
+const budgetEpsilon = 1e-9
+
+// Returns what a single impression costs at the given CPM.
+func ImpressionPrice(cpm float64) float64 {
+	return cpm / 1000
+}
+
+// Determines if the campaign's caps and budgets allow serving the given number
+// of additional impressions, costing amount in total, on the day of the given
+// time. Daily caps and budgets of zero are unlimited.
+func (c *Campaign) canServe(impressions int, amount float64, now time.Time) bool {
+	if c.ImpressionCount+impressions > c.MaxImpression {
+		return false
+	}
+	if c.DailyImpressionCap > 0 && c.dailyImpressions(now)+impressions > c.DailyImpressionCap {
+		return false
+	}
+	if c.TotalBudget > 0 && c.Spend+amount > c.TotalBudget+budgetEpsilon {
+		return false
+	}
+	return c.DailyBudget == 0 || c.dailySpend(now)+amount <= c.DailyBudget+budgetEpsilon
+}
+
+// Determines if the campaign has run out of impressions or of total budget
+// for another impression at its highest bid.
+func (c *Campaign) isExhausted() bool {
+	if c.ImpressionCount >= c.MaxImpression {
+		return true
+	}
+	return c.TotalBudget > 0 && c.Spend+ImpressionPrice(c.MaxBid()) > c.TotalBudget+budgetEpsilon
+}
@@ -0,0 +1,39 @@
+package campaign
+
+import "testing"
+
+func TestIsExhausted(t *testing.T) {
+	testcases := []struct {
+		name     string
+		input    *Campaign
+		expected bool
+	}{
+		{
+			name:     "Impressions left",
+			input:    &Campaign{CPM: 1000.0, MaxImpression: 2, ImpressionCount: 1},
+			expected: false,
+		},
+		{
+			name:     "No impressions left",
+			input:    &Campaign{CPM: 1000.0, MaxImpression: 2, ImpressionCount: 2},
+			expected: true,
+		},
+		{
+			name:     "Budget pays for another impression",
+			input:    &Campaign{CPM: 1000.0, MaxImpression: 10, TotalBudget: 3.0, Spend: 2.0},
+			expected: false,
+		},
+		{
+			name:     "Budget cannot pay for another impression at a keyword bid",
+			input:    &Campaign{CPM: 1000.0, KeywordBids: map[string]float64{"cat": 2000.0}, MaxImpression: 10, TotalBudget: 3.0, Spend: 2.0},
+			expected: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if found := tc.input.isExhausted(); found != tc.expected {
+				t.Errorf("Expected %t but Found %t", tc.expected, found)
+			}
+		})
+	}
+}
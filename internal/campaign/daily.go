@@ -0,0 +1,80 @@
+package campaign
+
+import (
+	"sync"
+	"time"
+)
+
+// Locations by name, since loading one reads the time zone database.
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// Returns the location of the campaign's timezone. Campaigns without a valid
+// timezone use UTC.
+func (c *Campaign) location() *time.Location {
+	loc, err := loadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Returns the date of the given time in the campaign's timezone, which the
+// daily counters are kept for.
+func (c *Campaign) day(now time.Time) string {
+	return now.In(c.location()).Format(time.DateOnly)
+}
+
+// Returns when the next day starts in the campaign's timezone, which is when
+// its daily caps reset.
+func (c *Campaign) NextDayStart(now time.Time) time.Time {
+	local := now.In(c.location())
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, local.Location())
+}
+
+// Returns the campaign's impressions counted on the day of the given time.
+func (c *Campaign) dailyImpressions(now time.Time) int {
+	if c.Day != c.day(now) {
+		return 0
+	}
+	return c.DailyImpressionCount
+}
+
+// Returns the campaign's spend for the day of the given time.
+func (c *Campaign) dailySpend(now time.Time) float64 {
+	if c.Day != c.day(now) {
+		return 0
+	}
+	return c.DailySpend
+}
+
+// Determines if the campaign has hit its daily impression cap, or spent too
+// much of its daily budget for another impression at its highest bid, on the
+// day of the given time.
+func (c *Campaign) DailyCapReached(now time.Time) bool {
+	if c.DailyImpressionCap > 0 && c.dailyImpressions(now) >= c.DailyImpressionCap {
+		return true
+	}
+	return c.DailyBudget > 0 && c.dailySpend(now)+ImpressionPrice(c.MaxBid()) > c.DailyBudget+budgetEpsilon
+}
+
+// Counts an impression served at the given price in the campaign's lifetime
+// and daily counters.
+func (c *Campaign) recordImpression(price float64, now time.Time) {
+	c.DailyImpressionCount = c.dailyImpressions(now) + 1
+	c.DailySpend = c.dailySpend(now) + price
+	c.Day = c.day(now)
+	c.ImpressionCount += 1
+	c.Spend += price
+}
@@ -0,0 +1,93 @@
+package campaign
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDayStart(t *testing.T) {
+	testcases := []struct {
+		name     string
+		timezone string
+		now      time.Time
+		expected time.Time
+	}{
+		{
+			name:     "UTC by default",
+			now:      time.Date(2024, 3, 9, 23, 30, 0, 0, time.UTC),
+			expected: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Midnight in the campaign's timezone",
+			timezone: "Asia/Tokyo",
+			now:      time.Date(2024, 3, 9, 14, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 3, 9, 15, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Day with a daylight saving change",
+			timezone: "America/New_York",
+			now:      time.Date(2024, 3, 10, 5, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 3, 11, 4, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Campaign{Timezone: tc.timezone}
+			if found := c.NextDayStart(tc.now); !found.Equal(tc.expected) {
+				t.Errorf("Expected %s but Found %s", tc.expected, found.UTC())
+			}
+		})
+	}
+}
+
+func TestDailyCapReached(t *testing.T) {
+	now := time.Date(2024, 3, 9, 14, 0, 0, 0, time.UTC)
+	testcases := []struct {
+		name     string
+		input    *Campaign
+		expected bool
+	}{
+		{
+			name:     "No daily caps",
+			input:    &Campaign{CPM: 1000.0, DailyImpressionCount: 100, DailySpend: 100.0, Day: "2024-03-09"},
+			expected: false,
+		},
+		{
+			name:     "Impression cap reached today",
+			input:    &Campaign{DailyImpressionCap: 2, DailyImpressionCount: 2, Day: "2024-03-09"},
+			expected: true,
+		},
+		{
+			name:     "Impression cap reached on a previous day",
+			input:    &Campaign{DailyImpressionCap: 2, DailyImpressionCount: 2, Day: "2024-03-08"},
+			expected: false,
+		},
+		{
+			name:     "Budget cannot pay for another impression",
+			input:    &Campaign{CPM: 1000.0, DailyBudget: 2.5, DailySpend: 2.0, Day: "2024-03-09"},
+			expected: true,
+		},
+		{
+			name:     "Budget cannot pay for another impression at a keyword bid",
+			input:    &Campaign{CPM: 100.0, KeywordBids: map[string]float64{"cat": 1000.0}, DailyBudget: 2.5, DailySpend: 2.0, Day: "2024-03-09"},
+			expected: true,
+		},
+		{
+			name: "Day already over in the campaign's timezone",
+			input: &Campaign{
+				DailyImpressionCap:   2,
+				DailyImpressionCount: 2,
+				Day:                  "2024-03-09",
+				Timezone:             "Pacific/Kiritimati",
+			},
+			expected: false,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if found := tc.input.DailyCapReached(now); found != tc.expected {
+				t.Errorf("Expected %t but Found %t", tc.expected, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package campaign
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	// Records the next campaign ID, so IDs of deleted campaigns are not
+	// reused after compaction.
+	opNextID = "next_id"
+	// The log is compacted once it holds this many times more records than
+	// there are campaigns, and at least minCompactRecords records.
+	compactRatio      = 4
+	minCompactRecords = 1000
+)
+
+// The open log a FileStore appends to.
+type logFile interface {
+	io.WriteSeeker
+	io.Closer
+	Truncate(size int64) error
+}
+
+// A single entry in the file store's log.
+type fileRecord struct {
+	Op       string    `json:"op"`
+	ID       int       `json:"id"`
+	Campaign *Campaign `json:"campaign,omitempty"`
+}
+
+// CampaignStore backed by an append-only log of JSON records on disk.
+//
+// Every write appends a record and the whole log is replayed into memory on
+// open, after which it is compacted down to one record per campaign. It is
+// compacted again whenever it grows too far past one record per campaign, e.g.
+// from counting impressions. A record that fails to be written is removed from
+// the log again, and one that was only partially written before a crash is
+// discarded when the log is replayed.
+//
+// The log also records the next campaign ID, so the IDs of deleted campaigns
+// are never handed out again.
+type FileStore struct {
+	mu        sync.RWMutex
+	path      string
+	file      logFile
+	campaigns map[int]*Campaign
+	nextID    int
+	// Number of records in the log.
+	records int
+}
+
+// Opens the store at path, creating the file if it does not exist.
+func OpenFileStore(path string) (*FileStore, error) {
+	campaigns, nextID, err := replayLog(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{path: path, campaigns: campaigns, nextID: nextID}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Returns the campaigns in the log at path and the next campaign ID.
+func replayLog(path string) (map[int]*Campaign, int, error) {
+	campaigns := make(map[int]*Campaign)
+	nextID := 0
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return campaigns, nextID, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var record fileRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return campaigns, nextID, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading campaign log %s: %w", path, err)
+		}
+		switch record.Op {
+		case opPut:
+			if record.Campaign == nil {
+				return nil, 0, fmt.Errorf("reading campaign log %s: put record for %d has no campaign", path, record.ID)
+			}
+			campaigns[record.ID] = record.Campaign
+			if record.ID >= nextID {
+				nextID = record.ID + 1
+			}
+		case opDelete:
+			delete(campaigns, record.ID)
+		case opNextID:
+			if record.ID > nextID {
+				nextID = record.ID
+			}
+		default:
+			return nil, 0, fmt.Errorf("reading campaign log %s: unknown op %q", path, record.Op)
+		}
+	}
+}
+
+// Rewrites the log with the next campaign ID and a single record per campaign
+// and continues appending to the new log. The previous log is kept if the new
+// one cannot be written.
+func (s *FileStore) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(tmp)
+	if err := encoder.Encode(fileRecord{Op: opNextID, ID: s.nextID}); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	for _, c := range sortedCampaigns(s.campaigns) {
+		if err := encoder.Encode(fileRecord{Op: opPut, ID: c.ID, Campaign: c}); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = tmp
+	s.records = len(s.campaigns) + 1
+	return nil
+}
+
+// Compacts the log if it has grown too large. The log stays valid when
+// compacting fails, so it is only retried on a later write.
+func (s *FileStore) maybeCompact() {
+	if s.records < minCompactRecords || s.records <= compactRatio*len(s.campaigns) {
+		return
+	}
+	if err := s.compact(); err != nil {
+		log.Printf("Failed to compact campaign log %s: %v\n", s.path, err)
+	}
+}
+
+// Appends a record to the log. A record that fails to be written is cut off
+// again, so later records do not follow a partial one.
+func (s *FileStore) append(record fileRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	offset, err := s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		if truncateErr := s.file.Truncate(offset); truncateErr != nil {
+			return fmt.Errorf("%w (and failed to remove the partial record: %v)", err, truncateErr)
+		}
+		if _, seekErr := s.file.Seek(offset, io.SeekStart); seekErr != nil {
+			return fmt.Errorf("%w (and failed to remove the partial record: %v)", err, seekErr)
+		}
+		return err
+	}
+	s.records++
+	return nil
+}
+
+func (s *FileStore) Put(c *Campaign) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(fileRecord{Op: opPut, ID: c.ID, Campaign: c}); err != nil {
+		return err
+	}
+	s.campaigns[c.ID] = c
+	if c.ID >= s.nextID {
+		s.nextID = c.ID + 1
+	}
+	s.maybeCompact()
+	return nil
+}
+
+func (s *FileStore) Get(id int) (*Campaign, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.campaigns[id]
+	if !ok {
+		return nil, ErrCampaignNotFound
+	}
+	return c, nil
+}
+
+func (s *FileStore) List() ([]*Campaign, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedCampaigns(s.campaigns), nil
+}
+
+func (s *FileStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.campaigns[id]; !ok {
+		return nil
+	}
+	if err := s.append(fileRecord{Op: opDelete, ID: id}); err != nil {
+		return err
+	}
+	delete(s.campaigns, id)
+	s.maybeCompact()
+	return nil
+}
+
+func (s *FileStore) NextID() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.nextID
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,168 @@
+package campaign
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "campaigns.db")
+	now := time.Unix(1684616602, 0)
+	campaigns := []*Campaign{
+		{
+			ID:             0,
+			StartTimestamp: now,
+			EndTimestamp:   now.Add(24 * time.Hour),
+			TargetKeywords: []string{"cat"},
+			MaxImpression:  10,
+			CPM:            2.0,
+		},
+		{
+			ID:             1,
+			StartTimestamp: now,
+			EndTimestamp:   now.Add(48 * time.Hour),
+			TargetKeywords: []string{"dog", "cat"},
+			MaxImpression:  5,
+			CPM:            3.5,
+		},
+		{
+			ID: 2,
+		},
+	}
+
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error opening store: %v", err)
+	}
+	for _, c := range campaigns {
+		if err := s.Put(c); err != nil {
+			t.Fatalf("Unexpected error on Put: %v", err)
+		}
+	}
+	campaigns[0].ImpressionCount = 4
+	s.Put(campaigns[0])
+	s.Delete(2)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error closing store: %v", err)
+	}
+
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening store: %v", err)
+	}
+	defer s.Close()
+	loaded, err := s.List()
+	if err != nil {
+		t.Fatalf("Unexpected error on List: %v", err)
+	}
+	if len(loaded) != 2 {
+		t.Fatalf("Expected 2 campaigns after reopening but Found %d", len(loaded))
+	}
+	for i, c := range loaded {
+		if !c.Equal(campaigns[i]) {
+			t.Errorf("Campaign changed after reopening.\nExpected: %+v\nFound: %+v", campaigns[i], c)
+		}
+	}
+}
+
+func TestFileStore_TruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "campaigns.db")
+	log := `{"op":"put","id":0,"campaign":{"id":0,"advertiser":"ad0"}}
+{"op":"put","id":1,"campaign":{"id":1,"adve`
+	if err := os.WriteFile(path, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected partially written record to be ignored but Found error: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.Get(0); err != nil {
+		t.Errorf("Expected complete record to be loaded but Found error: %v", err)
+	}
+	if _, err := s.Get(1); err == nil {
+		t.Error("Expected partially written record to be discarded.")
+	}
+}
+
+func TestFileStore_CompactsWhileOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "campaigns.db")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error opening store: %v", err)
+	}
+	c := &Campaign{ID: 0, MaxImpression: 2 * minCompactRecords}
+	for i := 0; i <= minCompactRecords; i++ {
+		updated := c.clone()
+		updated.ImpressionCount = i
+		if err := s.Put(updated); err != nil {
+			t.Fatalf("Unexpected error on Put: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error closing store: %v", err)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := strings.Count(string(contents), "\n"); records > compactRatio {
+		t.Errorf("Expected the log to be compacted but Found %d records", records)
+	}
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening store: %v", err)
+	}
+	defer s.Close()
+	if loaded, _ := s.Get(0); loaded == nil || loaded.ImpressionCount != minCompactRecords {
+		t.Errorf("Expected the latest campaign after reopening but Found %+v", loaded)
+	}
+}
+
+// Writes half of every write to the underlying file and then fails.
+type failingLogFile struct {
+	*os.File
+}
+
+func (f failingLogFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestFileStore_FailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "campaigns.db")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error opening store: %v", err)
+	}
+	file := s.file.(*os.File)
+	s.file = failingLogFile{file}
+	if err := s.Put(&Campaign{ID: 0, Advertiser: "ad0"}); err == nil {
+		t.Error("Expected the failed write to be reported.")
+	}
+	s.file = file
+	if err := s.Put(&Campaign{ID: 1, Advertiser: "ad1"}); err != nil {
+		t.Fatalf("Unexpected error on Put: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error closing store: %v", err)
+	}
+
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected the partial record to be removed but Found error: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.Get(0); err == nil {
+		t.Error("Expected the failed record to be discarded.")
+	}
+	if _, err := s.Get(1); err != nil {
+		t.Errorf("Expected the later record to be loaded but Found error: %v", err)
+	}
+}
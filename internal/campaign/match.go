@@ -0,0 +1,32 @@
+package campaign
+
+// How a target keyword matches the keywords of an ad decision request.
+type MatchType string
+
+const (
+	// The request has a keyword equal to the target keyword. This is the
+	// default.
+	MatchExact MatchType = "exact"
+	// A keyword of the request contains the target keyword's words in order
+	// and next to each other, e.g. "red running shoes" for "running shoes".
+	MatchPhrase MatchType = "phrase"
+	// The request contains all of the target keyword's words in any order and
+	// in any of its keywords, e.g. "shoes for running" for "running shoes".
+	MatchBroad MatchType = "broad"
+)
+
+func (m MatchType) isValid() bool {
+	switch m {
+	case "", MatchExact, MatchPhrase, MatchBroad:
+		return true
+	}
+	return false
+}
+
+// Returns how the given target keyword of the campaign is matched.
+func (c *Campaign) MatchTypeOf(keyword string) MatchType {
+	if m, ok := c.KeywordMatchTypes[keyword]; ok && m != "" {
+		return m
+	}
+	return MatchExact
+}
@@ -1,86 +1,262 @@
 package campaign
 
-import "time"
+import (
+	"math"
+	"time"
 
-// Full representation of a campaign.
+	"github.com/kriscampos/adserver/internal/frequency"
+)
+
+// Full representation of a campaign. NormalizedKeywords are the TargetKeywords
+// as they are matched against ad decision keywords.
+//
+// Budgets are in the same currency as the CPM. Budgets and the daily impression
+// cap are unlimited when zero. DailyImpressionCount and DailySpend are counted
+// on Day, a date in the campaign's Timezone, and reset at its midnight.
+// FrequencyCaps limit how often a single user is shown the campaign.
+//
+// KeywordMatchTypes maps target keywords to how they are matched, exactly by
+// default, and KeywordBids to the CPM bid for them, the campaign's CPM by
+// default. The campaign is never recommended for requests that contain one of
+// its NegativeKeywords as a phrase. Its Tier decides which campaigns it always
+// wins or loses against, standard by default.
 type Campaign struct {
-	ID              int
-	StartTimestamp  time.Time
-	EndTimestamp    time.Time
-	TargetKeywords  []string
-	ImpressionCount int
-	MaxImpression   int
-	CPM             float64
-	ImpressionURL   string
+	ID                   int                  `json:"id"`
+	StartTimestamp       time.Time            `json:"start_timestamp"`
+	EndTimestamp         time.Time            `json:"end_timestamp"`
+	TargetKeywords       []string             `json:"target_keywords"`
+	NormalizedKeywords   []string             `json:"normalized_keywords"`
+	ImpressionCount      int                  `json:"impression_count"`
+	MaxImpression        int                  `json:"max_impression"`
+	CPM                  float64              `json:"cpm"`
+	Advertiser           string               `json:"advertiser"`
+	Status               Status               `json:"status"`
+	TotalBudget          float64              `json:"total_budget"`
+	DailyBudget          float64              `json:"daily_budget"`
+	Spend                float64              `json:"spend"`
+	DailyImpressionCap   int                  `json:"daily_impression_cap"`
+	Timezone             string               `json:"timezone"`
+	Pacing               Pacing               `json:"pacing"`
+	Tier                 Tier                 `json:"tier"`
+	DailyImpressionCount int                  `json:"daily_impression_count"`
+	DailySpend           float64              `json:"daily_spend"`
+	Day                  string               `json:"day"`
+	FrequencyCaps        []frequency.Cap      `json:"frequency_caps"`
+	KeywordMatchTypes    map[string]MatchType `json:"keyword_match_types"`
+	NegativeKeywords     []string             `json:"negative_keywords"`
+	KeywordBids          map[string]float64   `json:"keyword_bids"`
 }
 
-// Version of campaign with information provided at request time.
-//
-// TODO: Validate fields when binding. e.g: end timestamp cannot be in the past.
+// Version of campaign with information provided at request time. Fields are
+// checked by Validate rather than when binding so every problem can be reported.
 type PostCampaignRequest struct {
-	StartTimestamp int64    `json:"start_timestamp" binding:"required"`
-	EndTimestamp   int64    `json:"end_timestamp" binding:"required"`
-	TargetKeywords []string `json:"target_keywords" binding:"required"`
-	MaxImpression  int      `json:"max_impression" binding:"required"`
-	CPM            float64  `json:"cpm" binding:"required"`
+	StartTimestamp     int64                `json:"start_timestamp"`
+	EndTimestamp       int64                `json:"end_timestamp"`
+	TargetKeywords     []string             `json:"target_keywords"`
+	MaxImpression      int                  `json:"max_impression"`
+	CPM                float64              `json:"cpm"`
+	Advertiser         string               `json:"advertiser"`
+	TotalBudget        float64              `json:"total_budget"`
+	DailyBudget        float64              `json:"daily_budget"`
+	DailyImpressionCap int                  `json:"daily_impression_cap"`
+	Timezone           string               `json:"timezone"`
+	Pacing             Pacing               `json:"pacing"`
+	Tier               Tier                 `json:"tier"`
+	FrequencyCaps      []frequency.Cap      `json:"frequency_caps"`
+	KeywordMatchTypes  map[string]MatchType `json:"keyword_match_types"`
+	NegativeKeywords   []string             `json:"negative_keywords"`
+	KeywordBids        map[string]float64   `json:"keyword_bids"`
+	Draft              bool                 `json:"draft"`
 }
 
-// Determines if a campaign is active.
-func (c *Campaign) isActive() bool {
-	now := time.Now()
-	return now.After(c.StartTimestamp) && now.Before(c.EndTimestamp)
+// Changes to a campaign. Only fields that are present are applied.
+type PatchCampaignRequest struct {
+	StartTimestamp     *int64               `json:"start_timestamp"`
+	EndTimestamp       *int64               `json:"end_timestamp"`
+	TargetKeywords     []string             `json:"target_keywords"`
+	MaxImpression      *int                 `json:"max_impression"`
+	CPM                *float64             `json:"cpm"`
+	Advertiser         *string              `json:"advertiser"`
+	TotalBudget        *float64             `json:"total_budget"`
+	DailyBudget        *float64             `json:"daily_budget"`
+	DailyImpressionCap *int                 `json:"daily_impression_cap"`
+	Timezone           *string              `json:"timezone"`
+	Pacing             *Pacing              `json:"pacing"`
+	Tier               *Tier                `json:"tier"`
+	FrequencyCaps      *[]frequency.Cap     `json:"frequency_caps"`
+	KeywordMatchTypes  map[string]MatchType `json:"keyword_match_types"`
+	NegativeKeywords   []string             `json:"negative_keywords"`
+	KeywordBids        map[string]float64   `json:"keyword_bids"`
 }
 
-// Determines if two campaigns are equal.
-func (c *Campaign) Equal(other *Campaign) bool {
-	if len(c.TargetKeywords) != len(other.TargetKeywords) {
+// Criteria for listing campaigns. Zero values match everything.
+type CampaignFilter struct {
+	Keyword    string `form:"keyword"`
+	Active     *bool  `form:"active"`
+	Status     Status `form:"status"`
+	Advertiser string `form:"advertiser"`
+	Page       int    `form:"page"`
+	PageSize   int    `form:"page_size"`
+}
+
+// Determines if a campaign's flight includes the given time.
+func (c *Campaign) isActive(now time.Time) bool {
+	return !now.Before(c.StartTimestamp) && now.Before(c.EndTimestamp)
+}
+
+// Determines if a campaign targets the given normalized keyword.
+func (c *Campaign) hasKeyword(keyword string) bool {
+	for _, k := range c.NormalizedKeywords {
+		if k == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// Determines if a campaign satisfies every criteria of the filter. Paging is ignored.
+func (c *Campaign) matches(f *CampaignFilter, now time.Time) bool {
+	if f.Keyword != "" && !c.hasKeyword(f.Keyword) {
 		return false
 	}
-	for i := range c.TargetKeywords {
-		if c.TargetKeywords[i] != other.TargetKeywords[i] {
-			return false
-		}
+	status := c.CurrentStatus(now)
+	if f.Active != nil && (status == StatusActive) != *f.Active {
+		return false
+	}
+	if f.Status != "" && status != f.Status {
+		return false
 	}
+	return f.Advertiser == "" || c.Advertiser == f.Advertiser
+}
+
+// Returns a copy of the campaign that does not share keywords with the original.
+func (c *Campaign) clone() *Campaign {
+	copied := *c
+	copied.TargetKeywords = append([]string(nil), c.TargetKeywords...)
+	copied.NormalizedKeywords = append([]string(nil), c.NormalizedKeywords...)
+	copied.FrequencyCaps = append([]frequency.Cap(nil), c.FrequencyCaps...)
+	copied.KeywordMatchTypes = copyMatchTypes(c.KeywordMatchTypes)
+	copied.NegativeKeywords = append([]string(nil), c.NegativeKeywords...)
+	copied.KeywordBids = copyBids(c.KeywordBids)
+	return &copied
+}
+
+// Determines if two campaigns are equal.
+func (c *Campaign) Equal(other *Campaign) bool {
 	return c.ID == other.ID &&
+		equalKeywords(c.TargetKeywords, other.TargetKeywords) &&
+		equalKeywords(c.NormalizedKeywords, other.NormalizedKeywords) &&
 		c.StartTimestamp.Equal(other.StartTimestamp) &&
 		c.EndTimestamp.Equal(other.EndTimestamp) &&
 		c.ImpressionCount == other.ImpressionCount &&
 		c.MaxImpression == other.MaxImpression &&
 		c.CPM == other.CPM &&
-		c.ImpressionURL == other.ImpressionURL
+		c.Advertiser == other.Advertiser &&
+		c.Status == other.Status &&
+		c.TotalBudget == other.TotalBudget &&
+		c.DailyBudget == other.DailyBudget &&
+		c.Spend == other.Spend &&
+		c.DailyImpressionCap == other.DailyImpressionCap &&
+		c.Timezone == other.Timezone &&
+		c.Pacing == other.Pacing &&
+		c.Tier == other.Tier &&
+		c.DailyImpressionCount == other.DailyImpressionCount &&
+		c.DailySpend == other.DailySpend &&
+		c.Day == other.Day &&
+		equalFrequencyCaps(c.FrequencyCaps, other.FrequencyCaps) &&
+		equalMatchTypes(c.KeywordMatchTypes, other.KeywordMatchTypes) &&
+		equalKeywords(c.NegativeKeywords, other.NegativeKeywords) &&
+		equalBids(c.KeywordBids, other.KeywordBids)
+}
+
+func equalKeywords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
-// returns -1 when this has more priority, 0 when this and other are equal,
-// and 1 when this has less priority.
-func (c *Campaign) Compare(other *Campaign) int {
-	// Check CPM
-	cpmDiff := c.CPM - other.CPM
-	if cpmDiff > 0 {
-		return -1
+func equalFrequencyCaps(a, b []frequency.Cap) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	if cpmDiff < 0 {
-		return 1
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
 	}
+	return true
+}
 
-	// CPM is equal, Check EndDates
-	now := time.Now()
-	timeDiff := c.EndTimestamp.Sub(now) - other.EndTimestamp.Sub(now)
-	if timeDiff < 0 {
-		return -1
+func equalMatchTypes(a, b map[string]MatchType) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	if timeDiff > 1 {
-		return 1
+	for k, m := range a {
+		if other, ok := b[k]; !ok || other != m {
+			return false
+		}
 	}
+	return true
+}
 
-	// EndDates are also equal. Use ID for tiebreaker.
-	idDiff := c.ID - other.ID
-	if idDiff < 0 {
-		return -1
+func copyMatchTypes(matchTypes map[string]MatchType) map[string]MatchType {
+	if matchTypes == nil {
+		return nil
 	}
-	if idDiff > 0 {
-		return 1
+	copied := make(map[string]MatchType, len(matchTypes))
+	for k, m := range matchTypes {
+		copied[k] = m
 	}
+	return copied
+}
 
-	// This should never happen in production code.
-	panic("Found two campaigns with the same ID. This should never happen.")
+func equalBids(a, b map[string]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, bid := range a {
+		if other, ok := b[k]; !ok || other != bid {
+			return false
+		}
+	}
+	return true
+}
+
+func copyBids(bids map[string]float64) map[string]float64 {
+	if bids == nil {
+		return nil
+	}
+	copied := make(map[string]float64, len(bids))
+	for k, bid := range bids {
+		copied[k] = bid
+	}
+	return copied
+}
+
+// Returns the CPM the campaign bids for one of its target keywords.
+func (c *Campaign) BidFor(keyword string) float64 {
+	if bid, ok := c.KeywordBids[keyword]; ok {
+		return bid
+	}
+	return c.CPM
+}
+
+// Returns the highest CPM the campaign bids for any of its keywords, which is
+// the most an impression of it can cost.
+func (c *Campaign) MaxBid() float64 {
+	return highestBid(c.CPM, c.KeywordBids)
+}
+
+func highestBid(cpm float64, bids map[string]float64) float64 {
+	highest := cpm
+	for _, bid := range bids {
+		highest = math.Max(highest, bid)
+	}
+	return highest
 }
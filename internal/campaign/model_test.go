@@ -38,7 +38,7 @@ func TestIsActive(t *testing.T) {
 	}
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
-			if actual := tc.input.isActive(); actual != tc.expected {
+			if actual := tc.input.isActive(time.Now()); actual != tc.expected {
 				t.Errorf("Expected %t but found %t for campaign: %+v\n", tc.expected, actual, tc.input)
 			}
 		})
@@ -63,7 +63,6 @@ func TestEqual(t *testing.T) {
 					ImpressionCount: 0,
 					MaxImpression:   1,
 					CPM:             2.0,
-					ImpressionURL:   "ad0",
 				},
 				{
 					ID:              0,
@@ -73,7 +72,6 @@ func TestEqual(t *testing.T) {
 					ImpressionCount: 0,
 					MaxImpression:   1,
 					CPM:             2.0,
-					ImpressionURL:   "ad0",
 				},
 			},
 			expected: true,
@@ -89,7 +87,6 @@ func TestEqual(t *testing.T) {
 					ImpressionCount: 0,
 					MaxImpression:   1,
 					CPM:             2.0,
-					ImpressionURL:   "ad0",
 				},
 				{
 					ID:              0,
@@ -99,7 +96,6 @@ func TestEqual(t *testing.T) {
 					ImpressionCount: 0,
 					MaxImpression:   1,
 					CPM:             2.0,
-					ImpressionURL:   "ad0",
 				},
 			},
 			expected: false,
@@ -115,7 +111,6 @@ func TestEqual(t *testing.T) {
 					ImpressionCount: 0,
 					MaxImpression:   1,
 					CPM:             2.0,
-					ImpressionURL:   "ad0",
 				},
 				{
 					ID:              0,
@@ -125,7 +120,6 @@ func TestEqual(t *testing.T) {
 					ImpressionCount: 0,
 					MaxImpression:   1,
 					CPM:             2.0,
-					ImpressionURL:   "ad0",
 				},
 			},
 			expected: false,
@@ -140,106 +134,3 @@ func TestEqual(t *testing.T) {
 		})
 	}
 }
-
-func TestCompare(t *testing.T) {
-	now := time.Now()
-	testCases := [][]*Campaign{
-		{
-			{
-				ID:           1,
-				CPM:          12.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-			{
-				ID:           2,
-				CPM:          10.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-		}, {
-			{
-				ID:           1,
-				CPM:          9.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-			{
-				ID:           2,
-				CPM:          10.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-		}, {
-			{
-				ID:           1,
-				CPM:          10.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-			{
-				ID:           2,
-				CPM:          10.0,
-				EndTimestamp: now.Add(3 * time.Hour),
-			},
-		}, {
-			{
-				ID:           1,
-				CPM:          10.0,
-				EndTimestamp: now.Add(3 * time.Hour),
-			},
-			{
-				ID:           2,
-				CPM:          10.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-		}, {
-			{
-				ID:           1,
-				CPM:          10.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-			{
-				ID:           2,
-				CPM:          10.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-		},
-		{
-			{
-				ID:           3,
-				CPM:          10.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-			{
-				ID:           2,
-				CPM:          10.0,
-				EndTimestamp: now.Add(2 * time.Hour),
-			},
-		},
-	}
-	expecteds := []int{
-		-1, 1, -1, 1, -1, 1,
-	}
-	for i, testCase := range testCases {
-		expected := expecteds[i]
-		actual := testCase[0].Compare(testCase[1])
-		if equals := expected == actual; !equals {
-			t.Errorf("Expected %d Found %d for campaigns %+v and %+v", expected, actual, testCase[0], testCase[1])
-		}
-	}
-}
-
-func TestCompare_Panic(t *testing.T) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("Expected a panic, but no panic occurred.")
-		}
-	}()
-
-	campaigns := []*Campaign{
-		{
-			ID: 0,
-		},
-		{
-			ID: 0,
-		},
-	}
-
-	campaigns[0].Compare(campaigns[1])
-}
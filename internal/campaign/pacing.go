@@ -0,0 +1,75 @@
+package campaign
+
+import (
+	"math"
+	"time"
+)
+
+// How a campaign spreads its impressions and budget over its flight.
+type Pacing string
+
+const (
+	// Serve as fast as possible. This is the default.
+	PacingASAP Pacing = "asap"
+	// Serve at a steady rate from start to end.
+	PacingEven Pacing = "even"
+	// Serve faster early in the flight and slow down towards its end.
+	PacingFrontLoaded Pacing = "front_loaded"
+)
+
+// Determines if the pacing can hold a campaign back.
+func (p Pacing) throttles() bool {
+	return p == PacingEven || p == PacingFrontLoaded
+}
+
+func (p Pacing) isValid() bool {
+	switch p {
+	case "", PacingASAP, PacingEven, PacingFrontLoaded:
+		return true
+	}
+	return false
+}
+
+// Predicts how ad decision traffic is spread over time, so paced campaigns
+// deliver in step with it rather than with the clock.
+type TrafficCurve interface {
+	// Returns the share of the traffic for the given normalized keywords
+	// between from and to that arrives before at.
+	Share(keywords []string, from, to, at time.Time) float64
+}
+
+// TrafficCurve that assumes traffic is the same at all times.
+type flatCurve struct{}
+
+func (flatCurve) Share(keywords []string, from, to, at time.Time) float64 {
+	flight := to.Sub(from)
+	if flight <= 0 {
+		return 1
+	}
+	return math.Min(math.Max(float64(at.Sub(from))/float64(flight), 0), 1)
+}
+
+// Returns the share of the campaign's impressions and budget that its pacing
+// expects to have been delivered once the given share of its flight's traffic
+// has arrived.
+func (c *Campaign) expectedDelivery(elapsed float64) float64 {
+	switch c.Pacing {
+	case PacingEven:
+		return elapsed
+	case PacingFrontLoaded:
+		return 1 - (1-elapsed)*(1-elapsed)
+	}
+	return 1
+}
+
+// Determines if serving the given number of additional impressions, costing
+// amount in total, keeps the campaign within its pacing once the given share of
+// its flight's traffic has arrived. A campaign may always run one impression
+// ahead, so it can start serving.
+func (c *Campaign) withinPace(impressions int, amount float64, elapsed float64) bool {
+	expected := c.expectedDelivery(elapsed)
+	if float64(c.ImpressionCount+impressions) > expected*float64(c.MaxImpression)+1 {
+		return false
+	}
+	return c.TotalBudget == 0 || c.Spend+amount <= expected*c.TotalBudget+ImpressionPrice(c.MaxBid())+budgetEpsilon
+}
@@ -0,0 +1,99 @@
+package campaign
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlatCurveShare(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	end := start.Add(100 * time.Hour)
+	testcases := []struct {
+		name     string
+		at       time.Time
+		expected float64
+	}{
+		{name: "Before the flight", at: start.Add(-time.Hour), expected: 0},
+		{name: "A quarter in", at: start.Add(25 * time.Hour), expected: 0.25},
+		{name: "After the flight", at: end.Add(time.Hour), expected: 1},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if found := (flatCurve{}).Share(nil, start, end, tc.at); found != tc.expected {
+				t.Errorf("Expected %f but Found %f", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestExpectedDelivery(t *testing.T) {
+	testcases := []struct {
+		name     string
+		pacing   Pacing
+		elapsed  float64
+		expected float64
+	}{
+		{name: "ASAP by default", elapsed: 0, expected: 1},
+		{name: "ASAP", pacing: PacingASAP, elapsed: 0.01, expected: 1},
+		{name: "Even at the start", pacing: PacingEven, elapsed: 0, expected: 0},
+		{name: "Even a quarter in", pacing: PacingEven, elapsed: 0.25, expected: 0.25},
+		{name: "Front-loaded a quarter in", pacing: PacingFrontLoaded, elapsed: 0.25, expected: 0.4375},
+		{name: "Front-loaded halfway", pacing: PacingFrontLoaded, elapsed: 0.5, expected: 0.75},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Campaign{Pacing: tc.pacing}
+			if found := c.expectedDelivery(tc.elapsed); found != tc.expected {
+				t.Errorf("Expected %f but Found %f", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestWithinPace(t *testing.T) {
+	// A quarter into the flight's traffic an even campaign is expected to have
+	// delivered 25 of its 100 impressions and 2.5 of its 10.0 budget.
+	testcases := []struct {
+		name     string
+		input    *Campaign
+		expected bool
+	}{
+		{
+			name:     "Behind pace",
+			input:    &Campaign{ImpressionCount: 10},
+			expected: true,
+		},
+		{
+			name:     "One impression ahead",
+			input:    &Campaign{ImpressionCount: 25},
+			expected: true,
+		},
+		{
+			name:     "More than one impression ahead",
+			input:    &Campaign{ImpressionCount: 26},
+			expected: false,
+		},
+		{
+			name:     "Ahead on spend",
+			input:    &Campaign{ImpressionCount: 10, TotalBudget: 10.0, Spend: 3.0},
+			expected: false,
+		},
+		{
+			name:     "ASAP is never throttled",
+			input:    &Campaign{ImpressionCount: 99, Pacing: PacingASAP},
+			expected: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.input.MaxImpression = 100
+			tc.input.CPM = 100.0
+			if tc.input.Pacing == "" {
+				tc.input.Pacing = PacingEven
+			}
+			if found := tc.input.withinPace(1, ImpressionPrice(tc.input.CPM), 0.25); found != tc.expected {
+				t.Errorf("Expected %t but Found %t", tc.expected, found)
+			}
+		})
+	}
+}
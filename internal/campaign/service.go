@@ -1,47 +1,482 @@
 package campaign
 
 import (
+	"errors"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/kriscampos/adserver/internal/clock"
+	"github.com/kriscampos/adserver/internal/frequency"
+	"github.com/kriscampos/adserver/internal/keyword"
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var (
+	ErrNotServable   = errors.New("campaign is not being served")
+	ErrNoCapacity    = errors.New("campaign has no impressions left")
+	ErrNoReservation = errors.New("campaign has no reserved impression")
+	ErrThrottled     = errors.New("campaign is ahead of its pacing")
+)
+
+// CampaignService creates, stores and updates campaigns. It is safe for
+// concurrent use.
+//
+// Campaigns returned by the service are never modified afterwards. Every change
+// stores a new copy of the campaign instead, so returned campaigns can be read
+// and served while other requests change the campaign.
+//
+// Impressions are reserved at their price when an ad decision is made and
+// counted when the impression is served. A campaign never has more impressions
+// counted and reserved than its caps allow, or more spent and reserved than its
+// budgets, so it cannot be over-served.
 type CampaignService struct {
-	impressionUrlToCampaign map[string]*Campaign
-	nextCampaignId          int
+	mu             sync.Mutex
+	store          CampaignStore
+	clock          clock.Clock
+	normalizer     *keyword.Normalizer
+	traffic        TrafficCurve
+	nextCampaignId int
+	// Reserved impressions per campaign ID. Reservations are not stored since
+	// they do not outlive the decisions they were made for.
+	reserved map[int]*reservation
 }
 
-func NewCampaignService() *CampaignService {
-	return &CampaignService{impressionUrlToCampaign: make(map[string]*Campaign)}
+type reservation struct {
+	impressions int
+	spend       float64
 }
 
-func (s *CampaignService) CreateCampaign(c *PostCampaignRequest) *Campaign {
-	id := s.nextCampaignId
-	s.nextCampaignId++
+// Configures optional behavior of a CampaignService.
+type Option func(*CampaignService)
+
+// Sets the clock used to validate flight dates and resolve statuses.
+func WithClock(c clock.Clock) Option {
+	return func(s *CampaignService) {
+		s.clock = c
+	}
+}
+
+// Sets the normalizer used to fill in NormalizedKeywords. It should be the
+// same one the ad engine matches keywords with.
+func WithNormalizer(normalizer *keyword.Normalizer) Option {
+	return func(s *CampaignService) {
+		s.normalizer = normalizer
+	}
+}
+
+// Sets the traffic curve paced campaigns deliver along. Traffic is assumed to
+// be flat by default.
+func WithTrafficCurve(traffic TrafficCurve) Option {
+	return func(s *CampaignService) {
+		s.traffic = traffic
+	}
+}
+
+func NewCampaignService(store CampaignStore, opts ...Option) *CampaignService {
+	s := &CampaignService{
+		store:      store,
+		clock:      clock.New(),
+		normalizer: keyword.NewNormalizer(keyword.DefaultConfig()),
+		traffic:    flatCurve{},
+		reserved:   make(map[int]*reservation),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.nextCampaignId = store.NextID()
+	return s
+}
+
+// Reads every campaign from the store so they can be served again, e.g.
+// after a restart. Returns the loaded campaigns ordered by ID.
+func (s *CampaignService) LoadCampaigns() ([]*Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	campaigns, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	now := s.clock.Now()
+	for i, c := range campaigns {
+		// The normalizer may have been configured differently when the
+		// campaign was stored.
+		if normalized := s.normalizer.NormalizeAll(c.TargetKeywords); !equalKeywords(normalized, c.NormalizedKeywords) {
+			updated := c.clone()
+			updated.NormalizedKeywords = normalized
+			if c, err = s.replace(updated); err != nil {
+				return nil, err
+			}
+		}
+		if c, err = s.refresh(c, now); err != nil {
+			return nil, err
+		}
+		campaigns[i] = c
+	}
+	return campaigns, nil
+}
+
+// Saves the status a campaign has reached through its flight dates, resets its
+// daily counters on a new day and returns the latest version of the campaign.
+func (s *CampaignService) refresh(c *Campaign, now time.Time) (*Campaign, error) {
+	current := c.CurrentStatus(now)
+	newDay := c.Day != "" && c.Day != c.day(now)
+	if current == c.Status && !newDay {
+		return c, nil
+	}
+	updated := c.clone()
+	updated.Status = current
+	if newDay {
+		updated.DailyImpressionCount = 0
+		updated.DailySpend = 0
+		updated.Day = c.day(now)
+	}
+	return s.replace(updated)
+}
+
+// Creates a campaign from a request, failing with a *ValidationError when the
+// request is invalid.
+func (s *CampaignService) CreateCampaign(c *PostCampaignRequest) (*Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	if err := c.Validate(now); err != nil {
+		return nil, err
+	}
 	newCampaign := &Campaign{
-		ID:              id,
-		StartTimestamp:  time.Unix(c.StartTimestamp, 0),
-		EndTimestamp:    time.Unix(c.EndTimestamp, 0),
-		TargetKeywords:  c.TargetKeywords,
-		ImpressionCount: 0,
-		MaxImpression:   c.MaxImpression,
-		CPM:             c.CPM,
-		ImpressionURL:   uuid.NewString(),
-	}
-	s.impressionUrlToCampaign[newCampaign.ImpressionURL] = newCampaign
-	return newCampaign
-}
-
-// Increments impression count and returns whether the max was hit and if the
-// impression url was valid.
-func (s *CampaignService) IncrementImpression(impressionURL string) (bool, bool) {
-	c, ok := s.impressionUrlToCampaign[impressionURL]
-	if ok {
-		c.ImpressionCount += 1
-		if c.ImpressionCount == c.MaxImpression {
-			return true, true
+		ID:                 s.nextCampaignId,
+		StartTimestamp:     time.Unix(c.StartTimestamp, 0),
+		EndTimestamp:       time.Unix(c.EndTimestamp, 0),
+		TargetKeywords:     append([]string(nil), c.TargetKeywords...),
+		ImpressionCount:    0,
+		MaxImpression:      c.MaxImpression,
+		CPM:                c.CPM,
+		Advertiser:         c.Advertiser,
+		Status:             StatusDraft,
+		TotalBudget:        c.TotalBudget,
+		DailyBudget:        c.DailyBudget,
+		DailyImpressionCap: c.DailyImpressionCap,
+		Timezone:           c.Timezone,
+		Pacing:             c.Pacing,
+		Tier:               c.Tier,
+		FrequencyCaps:      append([]frequency.Cap(nil), c.FrequencyCaps...),
+		KeywordMatchTypes:  copyMatchTypes(c.KeywordMatchTypes),
+		NegativeKeywords:   append([]string(nil), c.NegativeKeywords...),
+		KeywordBids:        copyBids(c.KeywordBids),
+	}
+	if err := s.normalizeKeywords(newCampaign); err != nil {
+		return nil, err
+	}
+	if !c.Draft {
+		newCampaign.Status = newCampaign.flightStatus(now)
+	}
+	if err := s.store.Put(newCampaign); err != nil {
+		return nil, err
+	}
+	s.nextCampaignId++
+	return newCampaign, nil
+}
+
+// Fills in a campaign's NormalizedKeywords, failing with a *ValidationError
+// when none of its keywords survive normalization.
+func (s *CampaignService) normalizeKeywords(c *Campaign) error {
+	c.NormalizedKeywords = s.normalizer.NormalizeAll(c.TargetKeywords)
+	if len(c.NormalizedKeywords) == 0 {
+		errs := &ValidationError{}
+		errs.add("target_keywords", CodeNoUsableKeyword, "must contain a keyword that is not only stop words")
+		return errs
+	}
+	return nil
+}
+
+// Returns the campaign with the given ID or ErrCampaignNotFound.
+func (s *CampaignService) GetCampaign(id int) (*Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.refresh(c, s.clock.Now())
+}
+
+// Returns one page of the campaigns matching the filter, ordered by ID, along
+// with the total number of matching campaigns.
+func (s *CampaignService) ListCampaigns(filter *CampaignFilter) ([]*Campaign, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	campaigns, err := s.store.List()
+	if err != nil {
+		return nil, 0, err
+	}
+	now := s.clock.Now()
+	normalizedFilter := *filter
+	normalizedFilter.Keyword = s.normalizer.Normalize(filter.Keyword)
+	matched := make([]*Campaign, 0)
+	for _, c := range campaigns {
+		if c, err = s.refresh(c, now); err != nil {
+			return nil, 0, err
+		}
+		if c.matches(&normalizedFilter, now) {
+			matched = append(matched, c)
+		}
+	}
+
+	page, pageSize := filter.Page, filter.PageSize
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	start := (page - 1) * pageSize
+	if start >= len(matched) {
+		return []*Campaign{}, len(matched), nil
+	}
+	end := start + pageSize
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[start:end], len(matched), nil
+}
+
+// Applies the changes in the request and saves the result, failing with a
+// *ValidationError when the changes are invalid.
+func (s *CampaignService) UpdateCampaign(id int, patch *PatchCampaignRequest) (*Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	updated := old.clone()
+	if patch.StartTimestamp != nil {
+		updated.StartTimestamp = time.Unix(*patch.StartTimestamp, 0)
+	}
+	if patch.EndTimestamp != nil {
+		updated.EndTimestamp = time.Unix(*patch.EndTimestamp, 0)
+	}
+	if patch.TargetKeywords != nil {
+		updated.TargetKeywords = append([]string(nil), patch.TargetKeywords...)
+	}
+	if patch.MaxImpression != nil {
+		updated.MaxImpression = *patch.MaxImpression
+	}
+	if patch.CPM != nil {
+		updated.CPM = *patch.CPM
+	}
+	if patch.Advertiser != nil {
+		updated.Advertiser = *patch.Advertiser
+	}
+	if patch.TotalBudget != nil {
+		updated.TotalBudget = *patch.TotalBudget
+	}
+	if patch.DailyBudget != nil {
+		updated.DailyBudget = *patch.DailyBudget
+	}
+	if patch.DailyImpressionCap != nil {
+		updated.DailyImpressionCap = *patch.DailyImpressionCap
+	}
+	if patch.Timezone != nil {
+		updated.Timezone = *patch.Timezone
+	}
+	if patch.Pacing != nil {
+		updated.Pacing = *patch.Pacing
+	}
+	if patch.Tier != nil {
+		updated.Tier = *patch.Tier
+	}
+	if patch.KeywordMatchTypes != nil {
+		updated.KeywordMatchTypes = copyMatchTypes(patch.KeywordMatchTypes)
+	}
+	if patch.KeywordBids != nil {
+		updated.KeywordBids = copyBids(patch.KeywordBids)
+	}
+	if patch.NegativeKeywords != nil {
+		updated.NegativeKeywords = append([]string(nil), patch.NegativeKeywords...)
+	}
+	if patch.FrequencyCaps != nil {
+		updated.FrequencyCaps = append([]frequency.Cap(nil), (*patch.FrequencyCaps)...)
+	}
+	now := s.clock.Now()
+	if err := patch.Validate(updated, now); err != nil {
+		return nil, err
+	}
+	if err := s.normalizeKeywords(updated); err != nil {
+		return nil, err
+	}
+	updated.reconcileStatus(now)
+	return s.replace(updated)
+}
+
+// Applies an event to a campaign's lifecycle, failing with ErrInvalidTransition
+// when the campaign's current status does not allow it.
+func (s *CampaignService) TransitionCampaign(id int, event Event) (*Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	next, err := old.nextStatus(event, s.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	updated := old.clone()
+	updated.Status = next
+	return s.replace(updated)
+}
+
+// Stores a new version of a campaign in place of the old one.
+func (s *CampaignService) replace(updated *Campaign) (*Campaign, error) {
+	if err := s.store.Put(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Removes a campaign and returns it.
+func (s *CampaignService) DeleteCampaign(id int) (*Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Delete(id); err != nil {
+		return nil, err
+	}
+	delete(s.reserved, id)
+	return c, nil
+}
+
+// Reserves an impression of a campaign at the given price for an ad decision.
+// Fails with ErrNotServable when the campaign is not active, ErrNoCapacity
+// when its remaining impressions or budget are already reserved and
+// ErrThrottled when it is ahead of its pacing.
+func (s *CampaignService) ReserveImpression(id int, price float64) (*Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := s.reserved[id]
+	if !ok {
+		r = &reservation{}
+	}
+	if err := s.checkReservation(c, r, price); err != nil {
+		return nil, err
+	}
+	r.impressions++
+	r.spend += price
+	s.reserved[id] = r
+	return c, nil
+}
+
+// Checks if an impression of a campaign could be reserved at the given price
+// without reserving it, failing with the error ReserveImpression would.
+func (s *CampaignService) CanReserveImpression(id int, price float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.store.Get(id)
+	if err != nil {
+		return err
+	}
+	r, ok := s.reserved[id]
+	if !ok {
+		r = &reservation{}
+	}
+	return s.checkReservation(c, r, price)
+}
+
+func (s *CampaignService) checkReservation(c *Campaign, r *reservation, price float64) error {
+	now := s.clock.Now()
+	if c.CurrentStatus(now) != StatusActive {
+		return ErrNotServable
+	}
+	if !c.canServe(r.impressions+1, r.spend+price, now) {
+		return ErrNoCapacity
+	}
+	if c.Pacing.throttles() {
+		elapsed := s.traffic.Share(c.NormalizedKeywords, c.StartTimestamp, c.EndTimestamp, now)
+		if !c.withinPace(r.impressions+1, r.spend+price, elapsed) {
+			return ErrThrottled
+		}
+	}
+	return nil
+}
+
+// Lowers the price of an impression reserved at from to to, e.g. once an
+// auction settled below the campaign's CPM. Fails with ErrNoReservation when
+// the campaign has no reserved impression.
+func (s *CampaignService) RepriceImpression(id int, from, to float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reserved[id]
+	if !ok {
+		return ErrNoReservation
+	}
+	r.spend += to - from
+	return nil
+}
+
+// Gives back an impression reserved at the given price for a decision that was
+// never served.
+func (s *CampaignService) ReleaseImpression(id int, price float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(id, price)
+}
+
+func (s *CampaignService) release(id int, price float64) {
+	r, ok := s.reserved[id]
+	if !ok {
+		return
+	}
+	if r.impressions <= 1 {
+		delete(s.reserved, id)
+		return
+	}
+	r.impressions--
+	r.spend -= price
+}
+
+// Counts an impression reserved at the given price and returns the campaign
+// and whether it hit a cap, i.e. it is exhausted or reached a daily cap, and
+// should stop being served for now. Fails with ErrNoReservation when the
+// campaign has no reserved impression and ErrNoCapacity when its caps or
+// budgets were lowered below what was reserved.
+func (s *CampaignService) CommitImpression(id int, price float64) (*Campaign, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.store.Get(id)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, ok := s.reserved[id]; !ok {
+		return nil, false, ErrNoReservation
+	}
+	s.release(id, price)
+	now := s.clock.Now()
+	if !c.canServe(1, price, now) {
+		return nil, false, ErrNoCapacity
+	}
+	updated := c.clone()
+	updated.recordImpression(price, now)
+	exhausted := updated.isExhausted()
+	if exhausted {
+		if next, err := updated.nextStatus(EventExhaust, now); err == nil {
+			updated.Status = next
 		}
-		return false, true
 	}
-	return false, false
+	if _, err := s.replace(updated); err != nil {
+		return nil, false, err
+	}
+	return updated, exhausted || updated.DailyCapReached(now), nil
 }
@@ -1,24 +1,47 @@
 package campaign
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kriscampos/adserver/internal/clock"
+	"github.com/kriscampos/adserver/internal/keyword"
 )
 
+// Returns a request that passes validation.
+func validPostCampaignRequest() *PostCampaignRequest {
+	now := time.Now()
+	return &PostCampaignRequest{
+		StartTimestamp: now.Unix(),
+		EndTimestamp:   now.Add(24 * time.Hour).Unix(),
+		TargetKeywords: []string{"dog"},
+		MaxImpression:  10,
+		CPM:            1.0,
+	}
+}
+
 func TestCreateCampaign(t *testing.T) {
-	s := NewCampaignService()
+	s := NewCampaignService(NewMemoryStore())
 	postCampaignRequest := &PostCampaignRequest{
-		StartTimestamp: 1684616602, // Saturday, May 20, 2023 9:03:22 PM GMT
-		EndTimestamp:   1687295002, // Tuesday, June 20, 2023 9:03:22 PM GMT
+		StartTimestamp: time.Now().Unix(),
+		EndTimestamp:   time.Now().Add(30 * 24 * time.Hour).Unix(),
 		TargetKeywords: []string{"dog"},
 		MaxImpression:  10,
 		CPM:            5.0,
 	}
-	campaignModel := s.CreateCampaign(postCampaignRequest)
+	campaignModel, err := s.CreateCampaign(postCampaignRequest)
+	if err != nil {
+		t.Fatalf("Unexpected error creating campaign: %v", err)
+	}
 
 	// Verify underlying storage was updated.
-	if equals := len(s.impressionUrlToCampaign) == 1; !equals {
-		t.Errorf("Campaign was not properly stored. Storage: %+v", s.impressionUrlToCampaign)
+	if stored, err := s.store.Get(campaignModel.ID); err != nil || stored != campaignModel {
+		t.Errorf("Campaign was not saved to the store. Found: %+v Error: %v", stored, err)
 	}
 
 	if equals := campaignModel.StartTimestamp.Equal(time.Unix(postCampaignRequest.StartTimestamp, 0)); !equals {
@@ -28,50 +51,65 @@ func TestCreateCampaign(t *testing.T) {
 	}
 }
 
-func TestIncrementImpression(t *testing.T) {
+func TestCommitImpression(t *testing.T) {
 	now := time.Now()
 	testcases := []struct {
 		name           string
-		getServiceFunc func() (*CampaignService, string)
-		expectOK       bool
+		getServiceFunc func() (*CampaignService, int)
+		expectedErr    error
 		expected       bool
 	}{
 		{
-			name: "Increment to less than max",
-			getServiceFunc: func() (*CampaignService, string) {
-				s := NewCampaignService()
-				c := s.CreateCampaign(&PostCampaignRequest{
+			name: "Commit to less than max",
+			getServiceFunc: func() (*CampaignService, int) {
+				s := NewCampaignService(NewMemoryStore())
+				c, _ := s.CreateCampaign(&PostCampaignRequest{
 					StartTimestamp: now.Unix(),
 					EndTimestamp:   now.Add(3 * time.Hour).Unix(),
 					TargetKeywords: []string{"dog"},
 					MaxImpression:  100,
 					CPM:            2.4,
 				})
-				return s, c.ImpressionURL
+				s.ReserveImpression(c.ID, 0)
+				return s, c.ID
 			},
-			expectOK: true,
 			expected: false,
 		},
 		{
-			name: "Increment to max",
-			getServiceFunc: func() (*CampaignService, string) {
-				s := NewCampaignService()
-				c := s.CreateCampaign(&PostCampaignRequest{
+			name: "Commit to max",
+			getServiceFunc: func() (*CampaignService, int) {
+				s := NewCampaignService(NewMemoryStore())
+				c, _ := s.CreateCampaign(&PostCampaignRequest{
 					StartTimestamp: now.Unix(),
 					EndTimestamp:   now.Add(3 * time.Hour).Unix(),
 					TargetKeywords: []string{"dog"},
 					MaxImpression:  1,
 					CPM:            2.4,
 				})
-				return s, c.ImpressionURL
+				s.ReserveImpression(c.ID, 0)
+				return s, c.ID
 			},
-			expectOK: true,
 			expected: true,
 		},
 		{
-			name: "Ensure bad url returns not okay",
-			getServiceFunc: func() (*CampaignService, string) {
-				s := NewCampaignService()
+			name: "Ensure commit without reservation fails",
+			getServiceFunc: func() (*CampaignService, int) {
+				s := NewCampaignService(NewMemoryStore())
+				c, _ := s.CreateCampaign(&PostCampaignRequest{
+					StartTimestamp: now.Unix(),
+					EndTimestamp:   now.Add(3 * time.Hour).Unix(),
+					TargetKeywords: []string{"dog"},
+					MaxImpression:  1,
+					CPM:            2.4,
+				})
+				return s, c.ID
+			},
+			expectedErr: ErrNoReservation,
+		},
+		{
+			name: "Ensure unknown campaign returns not found",
+			getServiceFunc: func() (*CampaignService, int) {
+				s := NewCampaignService(NewMemoryStore())
 				s.CreateCampaign(&PostCampaignRequest{
 					StartTimestamp: now.Unix(),
 					EndTimestamp:   now.Add(3 * time.Hour).Unix(),
@@ -79,22 +117,585 @@ func TestIncrementImpression(t *testing.T) {
 					MaxImpression:  1,
 					CPM:            2.4,
 				})
-				return s, "badURL"
+				return s, 42
 			},
-			expectOK: false,
-			expected: false,
+			expectedErr: ErrCampaignNotFound,
+			expected:    false,
 		},
 	}
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
-			s, impressionURL := tc.getServiceFunc()
-			reachedMax, ok := s.IncrementImpression(impressionURL)
-			if ok != tc.expectOK {
-				t.Errorf("OK: Expected %t but Found %t\n", tc.expectOK, ok)
+			s, id := tc.getServiceFunc()
+			c, capped, err := s.CommitImpression(id, 0)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected error %v but Found %v", tc.expectedErr, err)
 			}
-			if ok && reachedMax != tc.expected {
-				t.Errorf("Max Impressions Reached: Expected %t but Found %t\n", tc.expected, reachedMax)
+			if c != nil && capped != tc.expected {
+				t.Errorf("Capped: Expected %t but Found %t\n", tc.expected, capped)
 			}
 		})
 	}
 }
+
+func TestLoadCampaigns(t *testing.T) {
+	store := NewMemoryStore()
+	for _, id := range []int{3, 7} {
+		store.Put(&Campaign{ID: id, Advertiser: fmt.Sprintf("ad%d", id)})
+	}
+	s := NewCampaignService(store)
+	campaigns, err := s.LoadCampaigns()
+	if err != nil {
+		t.Fatalf("Unexpected error loading campaigns: %v", err)
+	}
+	if len(campaigns) != 2 {
+		t.Errorf("Expected 2 campaigns but Found %d", len(campaigns))
+	}
+
+	// New campaigns must not reuse loaded IDs.
+	c, err := s.CreateCampaign(validPostCampaignRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error creating campaign: %v", err)
+	}
+	if c.ID != 8 {
+		t.Errorf("Expected new campaign ID 8 but Found %d", c.ID)
+	}
+}
+
+func TestCreateCampaign_DoesNotReuseIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "campaigns.db")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error opening store: %v", err)
+	}
+	s := NewCampaignService(store)
+	for i := 0; i < 2; i++ {
+		if _, err := s.CreateCampaign(validPostCampaignRequest()); err != nil {
+			t.Fatalf("Unexpected error creating campaign: %v", err)
+		}
+	}
+	if _, err := s.DeleteCampaign(1); err != nil {
+		t.Fatalf("Unexpected error deleting campaign: %v", err)
+	}
+	store.Close()
+
+	// Reopening compacts the log, which drops the deleted campaign. The
+	// service is not loaded, so the ID must come from the store.
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening store: %v", err)
+	}
+	defer store.Close()
+	c, err := NewCampaignService(store).CreateCampaign(validPostCampaignRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error creating campaign: %v", err)
+	}
+	if c.ID != 2 {
+		t.Errorf("Expected new campaign ID 2 but Found %d", c.ID)
+	}
+	if _, err := store.Get(0); err != nil {
+		t.Errorf("Expected campaign 0 to be kept but Found error: %v", err)
+	}
+}
+
+func TestListCampaigns(t *testing.T) {
+	now := time.Now()
+	s := NewCampaignService(NewMemoryStore())
+	requests := []*PostCampaignRequest{
+		{
+			StartTimestamp: now.Add(-time.Hour).Unix(),
+			EndTimestamp:   now.Add(time.Hour).Unix(),
+			TargetKeywords: []string{"dog"},
+			MaxImpression:  10,
+			CPM:            1.0,
+			Advertiser:     "acme",
+		},
+		{
+			StartTimestamp: now.Add(time.Hour).Unix(),
+			EndTimestamp:   now.Add(2 * time.Hour).Unix(),
+			TargetKeywords: []string{"dog", "cat"},
+			MaxImpression:  10,
+			CPM:            1.0,
+			Advertiser:     "acme",
+		},
+		{
+			StartTimestamp: now.Add(-time.Hour).Unix(),
+			EndTimestamp:   now.Add(time.Hour).Unix(),
+			TargetKeywords: []string{"cat"},
+			MaxImpression:  10,
+			CPM:            1.0,
+			Advertiser:     "globex",
+		},
+	}
+	for _, r := range requests {
+		s.CreateCampaign(r)
+	}
+	active, inactive := true, false
+
+	testcases := []struct {
+		name          string
+		filter        CampaignFilter
+		expectedIDs   []int
+		expectedTotal int
+	}{
+		{
+			name:          "No filter",
+			filter:        CampaignFilter{},
+			expectedIDs:   []int{0, 1, 2},
+			expectedTotal: 3,
+		},
+		{
+			name:          "Keyword",
+			filter:        CampaignFilter{Keyword: "cat"},
+			expectedIDs:   []int{1, 2},
+			expectedTotal: 2,
+		},
+		{
+			name:          "Active",
+			filter:        CampaignFilter{Active: &active},
+			expectedIDs:   []int{0, 2},
+			expectedTotal: 2,
+		},
+		{
+			name:          "Inactive advertiser",
+			filter:        CampaignFilter{Active: &inactive, Advertiser: "acme"},
+			expectedIDs:   []int{1},
+			expectedTotal: 1,
+		},
+		{
+			name:          "Second page",
+			filter:        CampaignFilter{Page: 2, PageSize: 2},
+			expectedIDs:   []int{2},
+			expectedTotal: 3,
+		},
+		{
+			name:          "Page past the end",
+			filter:        CampaignFilter{Page: 3, PageSize: 2},
+			expectedIDs:   []int{},
+			expectedTotal: 3,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			campaigns, total, err := s.ListCampaigns(&tc.filter)
+			if err != nil {
+				t.Fatalf("Unexpected error listing campaigns: %v", err)
+			}
+			ids := []int{}
+			for _, c := range campaigns {
+				ids = append(ids, c.ID)
+			}
+			if !cmp.Equal(tc.expectedIDs, ids) {
+				t.Errorf("Expected: %+v Found: %+v", tc.expectedIDs, ids)
+			}
+			if total != tc.expectedTotal {
+				t.Errorf("Expected total %d but Found %d", tc.expectedTotal, total)
+			}
+		})
+	}
+}
+
+func TestUpdateCampaign(t *testing.T) {
+	s := NewCampaignService(NewMemoryStore())
+	original, _ := s.CreateCampaign(&PostCampaignRequest{
+		StartTimestamp: time.Now().Unix(),
+		EndTimestamp:   time.Now().Add(24 * time.Hour).Unix(),
+		TargetKeywords: []string{"dog"},
+		MaxImpression:  10,
+		CPM:            5.0,
+	})
+
+	cpm := 7.5
+	updated, err := s.UpdateCampaign(original.ID, &PatchCampaignRequest{
+		CPM:            &cpm,
+		TargetKeywords: []string{"cat"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error updating campaign: %v", err)
+	}
+	if updated.CPM != cpm || !cmp.Equal(updated.TargetKeywords, []string{"cat"}) {
+		t.Errorf("Changes were not applied. Found: %+v", updated)
+	}
+	if updated.MaxImpression != original.MaxImpression {
+		t.Errorf("Absent field was changed. Expected MaxImpression %d but Found %d", original.MaxImpression, updated.MaxImpression)
+	}
+	if original.CPM != 5.0 || !cmp.Equal(original.TargetKeywords, []string{"dog"}) {
+		t.Errorf("Original campaign was modified in place: %+v", original)
+	}
+	if stored, _ := s.GetCampaign(original.ID); stored != updated {
+		t.Errorf("Updated campaign was not stored. Found: %+v", stored)
+	}
+
+	if _, err := s.UpdateCampaign(42, &PatchCampaignRequest{}); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("Expected ErrCampaignNotFound but Found: %v", err)
+	}
+}
+
+func TestDeleteCampaign(t *testing.T) {
+	s := NewCampaignService(NewMemoryStore())
+	c, _ := s.CreateCampaign(validPostCampaignRequest())
+	if _, err := s.DeleteCampaign(c.ID); err != nil {
+		t.Fatalf("Unexpected error deleting campaign: %v", err)
+	}
+	if _, err := s.GetCampaign(c.ID); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("Expected ErrCampaignNotFound after delete but Found: %v", err)
+	}
+	if _, _, err := s.CommitImpression(c.ID, 0); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("Expected ErrCampaignNotFound counting an impression after delete but Found: %v", err)
+	}
+	if _, err := s.DeleteCampaign(c.ID); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("Expected ErrCampaignNotFound on second delete but Found: %v", err)
+	}
+}
+
+func TestTransitionCampaign(t *testing.T) {
+	now := time.Now()
+	s := NewCampaignService(NewMemoryStore())
+	c, _ := s.CreateCampaign(&PostCampaignRequest{
+		StartTimestamp: now.Add(-time.Hour).Unix(),
+		EndTimestamp:   now.Add(time.Hour).Unix(),
+		TargetKeywords: []string{"dog"},
+		MaxImpression:  10,
+		CPM:            1.0,
+		Draft:          true,
+	})
+	if c.Status != StatusDraft {
+		t.Fatalf("Expected new draft campaign to be %s but Found %s", StatusDraft, c.Status)
+	}
+
+	events := []Event{EventPublish, EventPause, EventResume}
+	expecteds := []Status{StatusActive, StatusPaused, StatusActive}
+	for i, event := range events {
+		updated, err := s.TransitionCampaign(c.ID, event)
+		if err != nil {
+			t.Fatalf("Unexpected error on %s: %v", event, err)
+		}
+		if updated.Status != expecteds[i] {
+			t.Errorf("Expected %s after %s but Found %s", expecteds[i], event, updated.Status)
+		}
+	}
+
+	if _, err := s.TransitionCampaign(c.ID, EventPublish); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Expected ErrInvalidTransition when publishing an active campaign but Found: %v", err)
+	}
+	if stored, _ := s.GetCampaign(c.ID); stored.Status != StatusActive {
+		t.Errorf("Rejected transition changed the status to %s", stored.Status)
+	}
+}
+
+func TestCommitImpression_Exhausts(t *testing.T) {
+	now := time.Now()
+	s := NewCampaignService(NewMemoryStore())
+	c, _ := s.CreateCampaign(&PostCampaignRequest{
+		StartTimestamp: now.Add(-time.Hour).Unix(),
+		EndTimestamp:   now.Add(time.Hour).Unix(),
+		TargetKeywords: []string{"dog"},
+		MaxImpression:  1,
+		CPM:            1.0,
+	})
+	s.ReserveImpression(c.ID, 0)
+	c, reachedMax, _ := s.CommitImpression(c.ID, 0)
+	if !reachedMax || c.Status != StatusExhausted {
+		t.Errorf("Expected campaign to be exhausted but Found reachedMax %t and status %s", reachedMax, c.Status)
+	}
+}
+
+func TestCreateCampaign_Invalid(t *testing.T) {
+	s := NewCampaignService(NewMemoryStore())
+	_, err := s.CreateCampaign(&PostCampaignRequest{CPM: -1})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a *ValidationError but Found: %v", err)
+	}
+	if campaigns, _, _ := s.ListCampaigns(&CampaignFilter{}); len(campaigns) != 0 {
+		t.Errorf("Invalid request created campaigns: %+v", campaigns)
+	}
+}
+
+func TestUpdateCampaign_Invalid(t *testing.T) {
+	s := NewCampaignService(NewMemoryStore())
+	original, _ := s.CreateCampaign(validPostCampaignRequest())
+	start := original.EndTimestamp.Add(time.Hour).Unix()
+	_, err := s.UpdateCampaign(original.ID, &PatchCampaignRequest{StartTimestamp: &start})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a *ValidationError but Found: %v", err)
+	}
+	if stored, _ := s.GetCampaign(original.ID); stored != original {
+		t.Errorf("Invalid update was stored: %+v", stored)
+	}
+}
+
+func TestCreateCampaign_NormalizesKeywords(t *testing.T) {
+	normalizer := keyword.NewNormalizer(keyword.Config{CaseFold: true, StopWords: keyword.DefaultStopWords})
+	s := NewCampaignService(NewMemoryStore(), WithNormalizer(normalizer))
+	request := validPostCampaignRequest()
+	request.TargetKeywords = []string{"Cat", "cat ", "The Dog"}
+	c, err := s.CreateCampaign(request)
+	if err != nil {
+		t.Fatalf("Unexpected error creating campaign: %v", err)
+	}
+	if !cmp.Equal(c.TargetKeywords, request.TargetKeywords) {
+		t.Errorf("Original keywords were changed. Expected: %+v Found: %+v", request.TargetKeywords, c.TargetKeywords)
+	}
+	if expected := []string{"cat", "dog"}; !cmp.Equal(c.NormalizedKeywords, expected) {
+		t.Errorf("Expected normalized keywords %+v but Found %+v", expected, c.NormalizedKeywords)
+	}
+	if campaigns, _, _ := s.ListCampaigns(&CampaignFilter{Keyword: "DOG"}); len(campaigns) != 1 {
+		t.Errorf("Expected keyword filter to be normalized but Found: %+v", campaigns)
+	}
+
+	request.TargetKeywords = []string{"the", "and"}
+	if _, err := s.CreateCampaign(request); err == nil {
+		t.Error("Expected campaign with only stop words to be rejected.")
+	}
+}
+
+func TestGetCampaign_StatusFollowsClock(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	fakeClock := clock.NewFake(start)
+	s := NewCampaignService(NewMemoryStore(), WithClock(fakeClock))
+	c, err := s.CreateCampaign(&PostCampaignRequest{
+		StartTimestamp: start.Add(time.Hour).Unix(),
+		EndTimestamp:   start.Add(2 * time.Hour).Unix(),
+		TargetKeywords: []string{"dog"},
+		MaxImpression:  10,
+		CPM:            1.0,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating campaign: %v", err)
+	}
+
+	expecteds := []Status{StatusScheduled, StatusActive, StatusExpired}
+	for _, expected := range expecteds {
+		if c, _ = s.GetCampaign(c.ID); c.Status != expected {
+			t.Errorf("Expected %s at %s but Found %s", expected, fakeClock.Now(), c.Status)
+		}
+		fakeClock.Advance(time.Hour)
+	}
+}
+
+func TestCampaignService_Concurrent(t *testing.T) {
+	s := NewCampaignService(NewMemoryStore())
+	request := validPostCampaignRequest()
+	request.MaxImpression = 1000
+	c, err := s.CreateCampaign(request)
+	if err != nil {
+		t.Fatalf("Unexpected error creating campaign: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := s.ReserveImpression(c.ID, 0); err != nil {
+					t.Errorf("Failed to reserve impression: %v", err)
+					return
+				}
+				if _, _, err := s.CommitImpression(c.ID, 0); err != nil {
+					t.Errorf("Failed to commit impression: %v", err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				s.CreateCampaign(validPostCampaignRequest())
+				s.ListCampaigns(&CampaignFilter{})
+				cpm := float64(i + 1)
+				s.UpdateCampaign(c.ID, &PatchCampaignRequest{CPM: &cpm})
+			}
+		}()
+	}
+	wg.Wait()
+
+	c, _ = s.GetCampaign(c.ID)
+	if c.ImpressionCount != 800 {
+		t.Errorf("Expected 800 impressions but Found %d", c.ImpressionCount)
+	}
+	if _, total, _ := s.ListCampaigns(&CampaignFilter{}); total != 401 {
+		t.Errorf("Expected 401 campaigns with unique IDs but Found %d", total)
+	}
+}
+
+func TestReserveImpression(t *testing.T) {
+	now := time.Now()
+	s := NewCampaignService(NewMemoryStore())
+	c, _ := s.CreateCampaign(&PostCampaignRequest{
+		StartTimestamp: now.Add(-time.Hour).Unix(),
+		EndTimestamp:   now.Add(time.Hour).Unix(),
+		TargetKeywords: []string{"dog"},
+		MaxImpression:  2,
+		CPM:            1.0,
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.ReserveImpression(c.ID, 0); err != nil {
+			t.Fatalf("Unexpected error reserving impression %d: %v", i, err)
+		}
+	}
+	if _, err := s.ReserveImpression(c.ID, 0); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("Expected ErrNoCapacity with every impression reserved but Found: %v", err)
+	}
+	s.ReleaseImpression(c.ID, 0)
+	if _, err := s.ReserveImpression(c.ID, 0); err != nil {
+		t.Errorf("Expected released impression to be reservable again but Found: %v", err)
+	}
+
+	// Lowering the max below the reserved impressions must not over-serve.
+	max := 1
+	s.UpdateCampaign(c.ID, &PatchCampaignRequest{MaxImpression: &max})
+	if _, reachedMax, err := s.CommitImpression(c.ID, 0); err != nil || !reachedMax {
+		t.Errorf("Expected first commit to reach the max but Found reachedMax %t and error %v", reachedMax, err)
+	}
+	if _, _, err := s.CommitImpression(c.ID, 0); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("Expected ErrNoCapacity committing past the max but Found: %v", err)
+	}
+	if c, _ = s.GetCampaign(c.ID); c.ImpressionCount != 1 {
+		t.Errorf("Expected 1 impression but Found %d", c.ImpressionCount)
+	}
+	if _, err := s.ReserveImpression(c.ID, 0); !errors.Is(err, ErrNotServable) {
+		t.Errorf("Expected ErrNotServable for an exhausted campaign but Found: %v", err)
+	}
+}
+
+func TestReserveImpression_Concurrent(t *testing.T) {
+	s := NewCampaignService(NewMemoryStore())
+	c, _ := s.CreateCampaign(validPostCampaignRequest())
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if _, err := s.ReserveImpression(c.ID, 0); err == nil {
+					s.CommitImpression(c.ID, 0)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c, _ = s.GetCampaign(c.ID); c.ImpressionCount != c.MaxImpression {
+		t.Errorf("Expected exactly %d impressions but Found %d", c.MaxImpression, c.ImpressionCount)
+	}
+}
+
+func TestCommitImpression_Budgets(t *testing.T) {
+	now := time.Unix(1684616602, 0)
+	fakeClock := clock.NewFake(now)
+	s := NewCampaignService(NewMemoryStore(), WithClock(fakeClock))
+	c, _ := s.CreateCampaign(&PostCampaignRequest{
+		StartTimestamp: now.Unix(),
+		EndTimestamp:   now.Add(30 * 24 * time.Hour).Unix(),
+		TargetKeywords: []string{"dog"},
+		MaxImpression:  100,
+		CPM:            1000.0,
+		TotalBudget:    3.0,
+		DailyBudget:    2.0,
+	})
+	price := ImpressionPrice(c.CPM)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.ReserveImpression(c.ID, price); err != nil {
+			t.Fatalf("Unexpected error reserving impression %d: %v", i, err)
+		}
+		// The second impression spends the daily budget.
+		if _, capped, err := s.CommitImpression(c.ID, price); err != nil || capped != (i == 1) {
+			t.Fatalf("Expected impression %d to be counted but Found capped %t and error %v", i, capped, err)
+		}
+	}
+	if _, err := s.ReserveImpression(c.ID, price); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("Expected ErrNoCapacity once the daily budget is spent but Found: %v", err)
+	}
+
+	fakeClock.Advance(24 * time.Hour)
+	if c, _ = s.GetCampaign(c.ID); c.DailySpend != 0 || c.Spend != 2.0 {
+		t.Errorf("Expected daily spend to reset but Found spend %f and daily spend %f", c.Spend, c.DailySpend)
+	}
+	s.ReserveImpression(c.ID, price)
+	c, capped, err := s.CommitImpression(c.ID, price)
+	if err != nil || !capped || c.Status != StatusExhausted {
+		t.Errorf("Expected the total budget to exhaust the campaign but Found: %+v Error: %v", c, err)
+	}
+
+	// Raising the budget revives the campaign.
+	budget := 10.0
+	if c, _ = s.UpdateCampaign(c.ID, &PatchCampaignRequest{TotalBudget: &budget}); c.Status != StatusActive {
+		t.Errorf("Expected campaign with budget left to be %s but Found %s", StatusActive, c.Status)
+	}
+}
+
+func TestCommitImpression_DailyImpressionCap(t *testing.T) {
+	// 23:00 in Tokyo.
+	now := time.Date(2024, 3, 9, 14, 0, 0, 0, time.UTC)
+	fakeClock := clock.NewFake(now)
+	s := NewCampaignService(NewMemoryStore(), WithClock(fakeClock))
+	c, err := s.CreateCampaign(&PostCampaignRequest{
+		StartTimestamp:     now.Unix(),
+		EndTimestamp:       now.Add(30 * 24 * time.Hour).Unix(),
+		TargetKeywords:     []string{"dog"},
+		MaxImpression:      100,
+		CPM:                1.0,
+		DailyImpressionCap: 2,
+		Timezone:           "Asia/Tokyo",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating campaign: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		s.ReserveImpression(c.ID, 0)
+		c, _, _ = s.CommitImpression(c.ID, 0)
+	}
+	if !c.DailyCapReached(now) {
+		t.Errorf("Expected the daily cap to be reached but Found: %+v", c)
+	}
+	if _, err := s.ReserveImpression(c.ID, 0); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("Expected ErrNoCapacity once the daily cap is reached but Found: %v", err)
+	}
+
+	fakeClock.Set(c.NextDayStart(now))
+	if _, err := s.ReserveImpression(c.ID, 0); err != nil {
+		t.Errorf("Expected the daily cap to reset at midnight in Tokyo but Found: %v", err)
+	}
+	if c, _ = s.GetCampaign(c.ID); c.ImpressionCount != 2 || c.DailyImpressionCount != 0 || c.Day != "2024-03-10" {
+		t.Errorf("Expected only the daily counters to reset but Found: %+v", c)
+	}
+}
+
+func TestRepriceImpression(t *testing.T) {
+	now := time.Unix(1684616602, 0)
+	s := NewCampaignService(NewMemoryStore(), WithClock(clock.NewFake(now)))
+	c, _ := s.CreateCampaign(&PostCampaignRequest{
+		StartTimestamp: now.Unix(),
+		EndTimestamp:   now.Add(24 * time.Hour).Unix(),
+		TargetKeywords: []string{"dog"},
+		MaxImpression:  100,
+		CPM:            1000.0,
+		TotalBudget:    1.5,
+	})
+
+	if err := s.RepriceImpression(c.ID, 1.0, 0.5); !errors.Is(err, ErrNoReservation) {
+		t.Errorf("Expected ErrNoReservation without a reservation but Found: %v", err)
+	}
+	if _, err := s.ReserveImpression(c.ID, 1.0); err != nil {
+		t.Fatalf("Unexpected error reserving impression: %v", err)
+	}
+	if err := s.CanReserveImpression(c.ID, 1.0); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("Expected ErrNoCapacity with the budget reserved but Found: %v", err)
+	}
+	// Repricing frees up budget for another impression.
+	if err := s.RepriceImpression(c.ID, 1.0, 0.5); err != nil {
+		t.Fatalf("Unexpected error repricing impression: %v", err)
+	}
+	if err := s.CanReserveImpression(c.ID, 1.0); err != nil {
+		t.Errorf("Expected budget left after repricing but Found: %v", err)
+	}
+	if c, _, _ = s.CommitImpression(c.ID, 0.5); c.Spend != 0.5 {
+		t.Errorf("Expected the repriced impression to spend 0.5 but Found %f", c.Spend)
+	}
+}
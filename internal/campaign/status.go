@@ -0,0 +1,134 @@
+package campaign
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// Stage of a campaign's lifecycle.
+type Status string
+
+const (
+	StatusDraft     Status = "draft"
+	StatusScheduled Status = "scheduled"
+	StatusActive    Status = "active"
+	StatusPaused    Status = "paused"
+	StatusExhausted Status = "exhausted"
+	StatusExpired   Status = "expired"
+	StatusArchived  Status = "archived"
+)
+
+// Something that happens to a campaign and may move it to another status.
+type Event string
+
+const (
+	EventPublish Event = "publish"
+	EventPause   Event = "pause"
+	EventResume  Event = "resume"
+	EventExhaust Event = "exhaust"
+	EventArchive Event = "archive"
+)
+
+// Allowed transitions keyed by the current status and the event.
+//
+// Moving between scheduled, active and expired is not driven by events but by
+// the flight dates, see CurrentStatus.
+var transitions = map[Status]map[Event]Status{
+	StatusDraft: {
+		EventPublish: StatusScheduled,
+		EventArchive: StatusArchived,
+	},
+	StatusScheduled: {
+		EventPause:   StatusPaused,
+		EventExhaust: StatusExhausted,
+		EventArchive: StatusArchived,
+	},
+	StatusActive: {
+		EventPause:   StatusPaused,
+		EventExhaust: StatusExhausted,
+		EventArchive: StatusArchived,
+	},
+	StatusPaused: {
+		EventResume:  StatusScheduled,
+		EventExhaust: StatusExhausted,
+		EventArchive: StatusArchived,
+	},
+	StatusExhausted: {
+		EventArchive: StatusArchived,
+	},
+	StatusExpired: {
+		EventArchive: StatusArchived,
+	},
+}
+
+// Returns the status of a campaign at the given time.
+//
+// Scheduled and active campaigns are resolved against their flight dates, and
+// paused campaigns expire once their end timestamp passes. Campaigns stored
+// without a status are treated as scheduled.
+func (c *Campaign) CurrentStatus(now time.Time) Status {
+	switch c.Status {
+	case "", StatusScheduled, StatusActive:
+		return c.flightStatus(now)
+	case StatusPaused:
+		if !now.Before(c.EndTimestamp) {
+			return StatusExpired
+		}
+	}
+	return c.Status
+}
+
+// Returns where the flight dates put a campaign that is being served.
+func (c *Campaign) flightStatus(now time.Time) Status {
+	if c.isActive(now) {
+		return StatusActive
+	}
+	if now.Before(c.StartTimestamp) {
+		return StatusScheduled
+	}
+	return StatusExpired
+}
+
+// Determines if a campaign should be served during its flight.
+func (c *Campaign) IsLive() bool {
+	return c.Status == "" || c.Status == StatusScheduled || c.Status == StatusActive
+}
+
+// Returns the status an event moves the campaign to at the given time.
+// Campaigns that are published or resumed without anything left to serve
+// become exhausted.
+func (c *Campaign) nextStatus(event Event, now time.Time) (Status, error) {
+	current := c.CurrentStatus(now)
+	next, ok := transitions[current][event]
+	if !ok {
+		return "", fmt.Errorf("%w: cannot %s a campaign that is %s", ErrInvalidTransition, event, current)
+	}
+	if next == StatusScheduled {
+		if c.isExhausted() {
+			return StatusExhausted, nil
+		}
+		next = c.flightStatus(now)
+	}
+	return next, nil
+}
+
+// Recomputes the status of a campaign after its flight dates, impression cap
+// or budget were changed, reviving it if it can be served again. Paused
+// campaigns that can no longer be served become exhausted.
+func (c *Campaign) reconcileStatus(now time.Time) {
+	switch c.Status {
+	case StatusExpired:
+		c.Status = StatusScheduled
+	case StatusExhausted:
+		if !c.isExhausted() {
+			c.Status = StatusScheduled
+		}
+	}
+	if (c.IsLive() || c.Status == StatusPaused) && c.isExhausted() {
+		c.Status = StatusExhausted
+	}
+	c.Status = c.CurrentStatus(now)
+}
@@ -0,0 +1,277 @@
+package campaign
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCurrentStatus(t *testing.T) {
+	now := time.Now()
+	past := Campaign{StartTimestamp: now.Add(-24 * time.Hour), EndTimestamp: now.Add(-12 * time.Hour)}
+	current := Campaign{StartTimestamp: now.Add(-12 * time.Hour), EndTimestamp: now.Add(12 * time.Hour)}
+	future := Campaign{StartTimestamp: now.Add(12 * time.Hour), EndTimestamp: now.Add(24 * time.Hour)}
+	withStatus := func(c Campaign, status Status) *Campaign {
+		c.Status = status
+		return &c
+	}
+
+	testcases := []struct {
+		name     string
+		input    *Campaign
+		expected Status
+	}{
+		{
+			name:     "Scheduled campaign in flight is active",
+			input:    withStatus(current, StatusScheduled),
+			expected: StatusActive,
+		},
+		{
+			name:     "Active campaign before flight is scheduled",
+			input:    withStatus(future, StatusActive),
+			expected: StatusScheduled,
+		},
+		{
+			name:     "Active campaign after flight is expired",
+			input:    withStatus(past, StatusActive),
+			expected: StatusExpired,
+		},
+		{
+			name:     "Campaign without status is resolved by flight",
+			input:    withStatus(current, ""),
+			expected: StatusActive,
+		},
+		{
+			name:     "Paused campaign in flight stays paused",
+			input:    withStatus(current, StatusPaused),
+			expected: StatusPaused,
+		},
+		{
+			name:     "Paused campaign after flight is expired",
+			input:    withStatus(past, StatusPaused),
+			expected: StatusExpired,
+		},
+		{
+			name:     "Draft ignores flight",
+			input:    withStatus(past, StatusDraft),
+			expected: StatusDraft,
+		},
+		{
+			name:     "Exhausted ignores flight",
+			input:    withStatus(past, StatusExhausted),
+			expected: StatusExhausted,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.input.CurrentStatus(now); actual != tc.expected {
+				t.Errorf("Expected %s but Found %s for campaign: %+v", tc.expected, actual, tc.input)
+			}
+		})
+	}
+}
+
+func TestNextStatus(t *testing.T) {
+	now := time.Now()
+	flight := func(status Status) *Campaign {
+		return &Campaign{
+			StartTimestamp: now.Add(-time.Hour),
+			EndTimestamp:   now.Add(time.Hour),
+			MaxImpression:  10,
+			Status:         status,
+		}
+	}
+
+	testcases := []struct {
+		name      string
+		input     *Campaign
+		event     Event
+		expected  Status
+		expectErr bool
+	}{
+		{
+			name:     "Publish draft in flight",
+			input:    flight(StatusDraft),
+			event:    EventPublish,
+			expected: StatusActive,
+		},
+		{
+			name:     "Pause active",
+			input:    flight(StatusActive),
+			event:    EventPause,
+			expected: StatusPaused,
+		},
+		{
+			name:     "Resume paused in flight",
+			input:    flight(StatusPaused),
+			event:    EventResume,
+			expected: StatusActive,
+		},
+		{
+			name:     "Exhaust active",
+			input:    flight(StatusActive),
+			event:    EventExhaust,
+			expected: StatusExhausted,
+		},
+		{
+			name:     "Archive exhausted",
+			input:    flight(StatusExhausted),
+			event:    EventArchive,
+			expected: StatusArchived,
+		},
+		{
+			name:      "Resume active",
+			input:     flight(StatusActive),
+			event:     EventResume,
+			expectErr: true,
+		},
+		{
+			name:      "Pause draft",
+			input:     flight(StatusDraft),
+			event:     EventPause,
+			expectErr: true,
+		},
+		{
+			name:      "Publish archived",
+			input:     flight(StatusArchived),
+			event:     EventPublish,
+			expectErr: true,
+		},
+		{
+			name: "Resume exhausted paused",
+			input: &Campaign{
+				StartTimestamp:  now.Add(-time.Hour),
+				EndTimestamp:    now.Add(time.Hour),
+				ImpressionCount: 2,
+				MaxImpression:   2,
+				Status:          StatusPaused,
+			},
+			event:    EventResume,
+			expected: StatusExhausted,
+		},
+		{
+			name: "Publish exhausted draft",
+			input: &Campaign{
+				StartTimestamp: now.Add(-time.Hour),
+				EndTimestamp:   now.Add(time.Hour),
+				MaxImpression:  10,
+				CPM:            1.0,
+				TotalBudget:    0.0001,
+				Status:         StatusDraft,
+			},
+			event:    EventPublish,
+			expected: StatusExhausted,
+		},
+		{
+			name: "Resume paused after flight",
+			input: &Campaign{
+				StartTimestamp: now.Add(-2 * time.Hour),
+				EndTimestamp:   now.Add(-time.Hour),
+				Status:         StatusPaused,
+			},
+			event:     EventResume,
+			expectErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := tc.input.nextStatus(tc.event, now)
+			if tc.expectErr {
+				if !errors.Is(err, ErrInvalidTransition) {
+					t.Errorf("Expected ErrInvalidTransition but Found status %s and error %v", actual, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("Expected %s but Found %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestReconcileStatus(t *testing.T) {
+	now := time.Now()
+	testcases := []struct {
+		name     string
+		input    *Campaign
+		expected Status
+	}{
+		{
+			name: "Extended expired campaign becomes active",
+			input: &Campaign{
+				StartTimestamp: now.Add(-time.Hour),
+				EndTimestamp:   now.Add(time.Hour),
+				MaxImpression:  10,
+				Status:         StatusExpired,
+			},
+			expected: StatusActive,
+		},
+		{
+			name: "Exhausted campaign with raised cap becomes active",
+			input: &Campaign{
+				StartTimestamp:  now.Add(-time.Hour),
+				EndTimestamp:    now.Add(time.Hour),
+				ImpressionCount: 5,
+				MaxImpression:   10,
+				Status:          StatusExhausted,
+			},
+			expected: StatusActive,
+		},
+		{
+			name: "Active campaign with lowered cap becomes exhausted",
+			input: &Campaign{
+				StartTimestamp:  now.Add(-time.Hour),
+				EndTimestamp:    now.Add(time.Hour),
+				ImpressionCount: 5,
+				MaxImpression:   5,
+				Status:          StatusActive,
+			},
+			expected: StatusExhausted,
+		},
+		{
+			name: "Active campaign with lowered budget becomes exhausted",
+			input: &Campaign{
+				StartTimestamp: now.Add(-time.Hour),
+				EndTimestamp:   now.Add(time.Hour),
+				MaxImpression:  10,
+				CPM:            1000.0,
+				TotalBudget:    2.5,
+				Spend:          2.0,
+				Status:         StatusActive,
+			},
+			expected: StatusExhausted,
+		},
+		{
+			name: "Paused campaign with lowered cap becomes exhausted",
+			input: &Campaign{
+				StartTimestamp:  now.Add(-time.Hour),
+				EndTimestamp:    now.Add(time.Hour),
+				ImpressionCount: 2,
+				MaxImpression:   2,
+				Status:          StatusPaused,
+			},
+			expected: StatusExhausted,
+		},
+		{
+			name: "Paused campaign stays paused",
+			input: &Campaign{
+				StartTimestamp: now.Add(-time.Hour),
+				EndTimestamp:   now.Add(time.Hour),
+				MaxImpression:  10,
+				Status:         StatusPaused,
+			},
+			expected: StatusPaused,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.input.reconcileStatus(now)
+			if tc.input.Status != tc.expected {
+				t.Errorf("Expected %s but Found %s", tc.expected, tc.input.Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package campaign
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var ErrCampaignNotFound = errors.New("campaign not found")
+
+// CampaignStore persists campaigns between restarts.
+//
+// Stores hand out the same *Campaign for an ID until it is replaced, so
+// callers are expected to Put a campaign after mutating it.
+type CampaignStore interface {
+	// Saves a campaign, replacing any campaign with the same ID.
+	Put(c *Campaign) error
+	// Returns the campaign with the given ID or ErrCampaignNotFound.
+	Get(id int) (*Campaign, error)
+	// Returns every stored campaign ordered by ID.
+	List() ([]*Campaign, error)
+	// Removes a campaign. Deleting a missing campaign is not an error.
+	Delete(id int) error
+	// Returns an ID higher than that of every campaign ever stored, including
+	// deleted ones, so IDs are never reused.
+	NextID() int
+	Close() error
+}
+
+// CampaignStore that only lives as long as the process.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	campaigns map[int]*Campaign
+	nextID    int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{campaigns: make(map[int]*Campaign)}
+}
+
+func (m *MemoryStore) Put(c *Campaign) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.campaigns[c.ID] = c
+	if c.ID >= m.nextID {
+		m.nextID = c.ID + 1
+	}
+	return nil
+}
+
+func (m *MemoryStore) Get(id int) (*Campaign, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.campaigns[id]
+	if !ok {
+		return nil, ErrCampaignNotFound
+	}
+	return c, nil
+}
+
+func (m *MemoryStore) List() ([]*Campaign, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sortedCampaigns(m.campaigns), nil
+}
+
+func (m *MemoryStore) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.campaigns, id)
+	return nil
+}
+
+func (m *MemoryStore) NextID() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.nextID
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func sortedCampaigns(campaigns map[int]*Campaign) []*Campaign {
+	list := make([]*Campaign, 0, len(campaigns))
+	for _, c := range campaigns {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
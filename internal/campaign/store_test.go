@@ -0,0 +1,39 @@
+package campaign
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	for _, id := range []int{2, 0, 1} {
+		if err := s.Put(&Campaign{ID: id}); err != nil {
+			t.Fatalf("Unexpected error on Put: %v", err)
+		}
+	}
+	if err := s.Delete(1); err != nil {
+		t.Fatalf("Unexpected error on Delete: %v", err)
+	}
+
+	if _, err := s.Get(1); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("Expected ErrCampaignNotFound for deleted campaign but Found: %v", err)
+	}
+	if c, err := s.Get(2); err != nil || c.ID != 2 {
+		t.Errorf("Expected campaign 2 but Found: %+v Error: %v", c, err)
+	}
+
+	campaigns, err := s.List()
+	if err != nil {
+		t.Fatalf("Unexpected error on List: %v", err)
+	}
+	ids := []int{}
+	for _, c := range campaigns {
+		ids = append(ids, c.ID)
+	}
+	if expected := []int{0, 2}; !cmp.Equal(expected, ids) {
+		t.Errorf("Expected: %+v Found: %+v", expected, ids)
+	}
+}
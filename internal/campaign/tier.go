@@ -0,0 +1,43 @@
+package campaign
+
+// Priority tier of a campaign. Campaigns in a higher tier always win over
+// campaigns in a lower tier, whatever they bid.
+type Tier string
+
+const (
+	// Sponsorships, e.g. owning a keyword for a period, come first.
+	TierSponsorship Tier = "sponsorship"
+	// Campaigns sold with a guaranteed number of impressions.
+	TierGuaranteed Tier = "guaranteed"
+	// Campaigns competing on price. This is the default.
+	TierStandard Tier = "standard"
+	// The publisher's own campaigns, which fill requests nothing else is
+	// served for.
+	TierHouse Tier = "house"
+)
+
+func (t Tier) isValid() bool {
+	switch t {
+	case "", TierSponsorship, TierGuaranteed, TierStandard, TierHouse:
+		return true
+	}
+	return false
+}
+
+// Returns the campaign's tier as a number that is higher for higher tiers.
+func (c *Campaign) TierRank() int {
+	switch c.Tier {
+	case TierSponsorship:
+		return 3
+	case TierGuaranteed:
+		return 2
+	case TierHouse:
+		return 0
+	}
+	return 1
+}
+
+// Determines if the campaign is a house campaign.
+func (c *Campaign) IsHouse() bool {
+	return c.Tier == TierHouse
+}
@@ -0,0 +1,304 @@
+package campaign
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kriscampos/adserver/internal/frequency"
+)
+
+// Machine-readable reason a field was rejected.
+type ErrorCode string
+
+const (
+	CodeRequired         ErrorCode = "required"
+	CodeInvalidType      ErrorCode = "invalid_type"
+	CodeNotPositive      ErrorCode = "not_positive"
+	CodeNegative         ErrorCode = "negative"
+	CodeInPast           ErrorCode = "in_past"
+	CodeNotAfterStart    ErrorCode = "not_after_start"
+	CodeEmptyKeyword     ErrorCode = "empty_keyword"
+	CodeControlCharacter ErrorCode = "control_character"
+	CodeDuplicateKeyword ErrorCode = "duplicate_keyword"
+	CodeNoUsableKeyword  ErrorCode = "no_usable_keyword"
+	CodeUnknownTimezone  ErrorCode = "unknown_timezone"
+	CodeUnknownPacing    ErrorCode = "unknown_pacing"
+	CodeUnknownTier      ErrorCode = "unknown_tier"
+	CodeWindowTooLong    ErrorCode = "window_too_long"
+	CodeUnknownMatchType ErrorCode = "unknown_match_type"
+	CodeUnknownKeyword   ErrorCode = "unknown_keyword"
+	CodeBelowPrice       ErrorCode = "below_impression_price"
+)
+
+// A single problem with a field of a request.
+type FieldError struct {
+	Field   string    `json:"field"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// Every problem found with a request.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = fmt.Sprintf("%s: %s", f.Field, f.Message)
+	}
+	return "invalid campaign: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field string, code ErrorCode, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Returns the error if any problems were found and nil otherwise.
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Checks every field of the request, returning a *ValidationError listing all problems.
+func (r *PostCampaignRequest) Validate(now time.Time) error {
+	errs := &ValidationError{}
+	if r.StartTimestamp == 0 {
+		errs.add("start_timestamp", CodeRequired, "is required")
+	}
+	if r.EndTimestamp == 0 {
+		errs.add("end_timestamp", CodeRequired, "is required")
+	} else {
+		validateFlight(errs, time.Unix(r.StartTimestamp, 0), time.Unix(r.EndTimestamp, 0), now)
+	}
+	if len(r.TargetKeywords) == 0 {
+		errs.add("target_keywords", CodeRequired, "must contain at least one keyword")
+	} else {
+		validateKeywords(errs, r.TargetKeywords)
+	}
+	if r.MaxImpression == 0 {
+		errs.add("max_impression", CodeRequired, "is required")
+	} else {
+		validatePositive(errs, "max_impression", float64(r.MaxImpression))
+	}
+	if r.CPM == 0 {
+		errs.add("cpm", CodeRequired, "is required")
+	} else {
+		validatePositive(errs, "cpm", r.CPM)
+	}
+	maxBid := highestBid(r.CPM, r.KeywordBids)
+	validateBudget(errs, "total_budget", r.TotalBudget, maxBid)
+	validateBudget(errs, "daily_budget", r.DailyBudget, maxBid)
+	validateNonNegative(errs, "daily_impression_cap", float64(r.DailyImpressionCap))
+	validateTimezone(errs, r.Timezone)
+	validatePacing(errs, r.Pacing)
+	validateTier(errs, r.Tier)
+	validateFrequencyCaps(errs, r.FrequencyCaps)
+	validateMatchTypes(errs, r.KeywordMatchTypes, r.TargetKeywords)
+	validateNegativeKeywords(errs, r.NegativeKeywords)
+	validateBids(errs, r.KeywordBids, r.TargetKeywords)
+	return errs.orNil()
+}
+
+// Checks the fields present in the request along with the campaign it would
+// produce, returning a *ValidationError listing all problems.
+func (r *PatchCampaignRequest) Validate(updated *Campaign, now time.Time) error {
+	errs := &ValidationError{}
+	if r.StartTimestamp != nil || r.EndTimestamp != nil {
+		validateFlight(errs, updated.StartTimestamp, updated.EndTimestamp, now)
+	}
+	if r.TargetKeywords != nil {
+		if len(r.TargetKeywords) == 0 {
+			errs.add("target_keywords", CodeRequired, "must contain at least one keyword")
+		}
+		validateKeywords(errs, r.TargetKeywords)
+	}
+	if r.MaxImpression != nil {
+		validatePositive(errs, "max_impression", float64(*r.MaxImpression))
+	}
+	if r.CPM != nil {
+		validatePositive(errs, "cpm", *r.CPM)
+	}
+	bidChanged := r.CPM != nil || r.KeywordBids != nil
+	if r.TotalBudget != nil || bidChanged {
+		validateBudget(errs, "total_budget", updated.TotalBudget, updated.MaxBid())
+	}
+	if r.DailyBudget != nil || bidChanged {
+		validateBudget(errs, "daily_budget", updated.DailyBudget, updated.MaxBid())
+	}
+	if r.DailyImpressionCap != nil {
+		validateNonNegative(errs, "daily_impression_cap", float64(*r.DailyImpressionCap))
+	}
+	if r.Timezone != nil {
+		validateTimezone(errs, *r.Timezone)
+	}
+	if r.Pacing != nil {
+		validatePacing(errs, *r.Pacing)
+	}
+	if r.Tier != nil {
+		validateTier(errs, *r.Tier)
+	}
+	if r.FrequencyCaps != nil {
+		validateFrequencyCaps(errs, *r.FrequencyCaps)
+	}
+	if r.KeywordMatchTypes != nil || r.TargetKeywords != nil {
+		validateMatchTypes(errs, updated.KeywordMatchTypes, updated.TargetKeywords)
+	}
+	if r.KeywordBids != nil || r.TargetKeywords != nil {
+		validateBids(errs, updated.KeywordBids, updated.TargetKeywords)
+	}
+	if r.NegativeKeywords != nil {
+		validateNegativeKeywords(errs, r.NegativeKeywords)
+	}
+	return errs.orNil()
+}
+
+func validateFlight(errs *ValidationError, start, end, now time.Time) {
+	if !end.After(now) {
+		errs.add("end_timestamp", CodeInPast, "must be in the future")
+	}
+	if !end.After(start) {
+		errs.add("end_timestamp", CodeNotAfterStart, "must be after start_timestamp")
+	}
+}
+
+func validateKeywords(errs *ValidationError, keywords []string) {
+	seen := make(map[string]bool)
+	for i, keyword := range keywords {
+		field := fmt.Sprintf("target_keywords[%d]", i)
+		trimmed := strings.TrimSpace(keyword)
+		if trimmed == "" {
+			errs.add(field, CodeEmptyKeyword, "must not be empty")
+			continue
+		}
+		validateKeywordText(errs, field, trimmed)
+		if seen[trimmed] {
+			errs.add(field, CodeDuplicateKeyword, "duplicates keyword %q", trimmed)
+		}
+		seen[trimmed] = true
+	}
+}
+
+func isControl(r rune) bool {
+	return unicode.IsControl(r) && !unicode.IsSpace(r)
+}
+
+func validatePositive(errs *ValidationError, field string, value float64) {
+	if value <= 0 {
+		errs.add(field, CodeNotPositive, "must be greater than zero")
+	}
+}
+
+func validateNonNegative(errs *ValidationError, field string, value float64) {
+	if value < 0 {
+		errs.add(field, CodeNegative, "must not be negative")
+	}
+}
+
+// Checks that a budget is either unlimited or pays for at least one impression
+// at the campaign's highest bid, since the campaign could never be served
+// otherwise.
+func validateBudget(errs *ValidationError, field string, budget, maxBid float64) {
+	validateNonNegative(errs, field, budget)
+	if budget > 0 && budget+budgetEpsilon < ImpressionPrice(maxBid) {
+		errs.add(field, CodeBelowPrice, "must pay for at least one impression at the highest bid of %g", maxBid)
+	}
+}
+
+func validateTimezone(errs *ValidationError, name string) {
+	if _, err := loadLocation(name); err != nil {
+		errs.add("timezone", CodeUnknownTimezone, "%q is not a known IANA timezone", name)
+	}
+}
+
+func validatePacing(errs *ValidationError, pacing Pacing) {
+	if !pacing.isValid() {
+		errs.add("pacing", CodeUnknownPacing, "must be one of %s, %s or %s", PacingASAP, PacingEven, PacingFrontLoaded)
+	}
+}
+
+func validateTier(errs *ValidationError, tier Tier) {
+	if !tier.isValid() {
+		errs.add("tier", CodeUnknownTier, "must be one of %s, %s, %s or %s", TierSponsorship, TierGuaranteed, TierStandard, TierHouse)
+	}
+}
+
+func validateFrequencyCaps(errs *ValidationError, caps []frequency.Cap) {
+	var capsErr *frequency.CapsError
+	if !errors.As(frequency.ValidateCaps(caps), &capsErr) {
+		return
+	}
+	for _, f := range capsErr.Fields {
+		code := CodeNotPositive
+		if errors.Is(f.Err, frequency.ErrWindowTooLong) {
+			code = CodeWindowTooLong
+		}
+		errs.add(fmt.Sprintf("frequency_caps[%d].%s", f.Index, f.Field), code, "%v", f.Err)
+	}
+}
+
+func validateMatchTypes(errs *ValidationError, matchTypes map[string]MatchType, keywords []string) {
+	targeted := targetedKeywords(keywords)
+	for _, keyword := range sortedKeys(matchTypes) {
+		field := fmt.Sprintf("keyword_match_types[%q]", keyword)
+		if !targeted[keyword] {
+			errs.add(field, CodeUnknownKeyword, "%q is not one of target_keywords", keyword)
+		}
+		if m := matchTypes[keyword]; !m.isValid() {
+			errs.add(field, CodeUnknownMatchType, "must be one of %s, %s or %s", MatchExact, MatchPhrase, MatchBroad)
+		}
+	}
+}
+
+func validateNegativeKeywords(errs *ValidationError, keywords []string) {
+	for i, keyword := range keywords {
+		field := fmt.Sprintf("negative_keywords[%d]", i)
+		if strings.TrimSpace(keyword) == "" {
+			errs.add(field, CodeEmptyKeyword, "must not be empty")
+			continue
+		}
+		validateKeywordText(errs, field, keyword)
+	}
+}
+
+// Keywords are only made of printable text and whitespace.
+func validateKeywordText(errs *ValidationError, field, keyword string) {
+	if strings.IndexFunc(keyword, isControl) >= 0 {
+		errs.add(field, CodeControlCharacter, "must not contain control characters")
+	}
+}
+
+func validateBids(errs *ValidationError, bids map[string]float64, keywords []string) {
+	targeted := targetedKeywords(keywords)
+	for _, keyword := range sortedKeys(bids) {
+		field := fmt.Sprintf("keyword_bids[%q]", keyword)
+		if !targeted[keyword] {
+			errs.add(field, CodeUnknownKeyword, "%q is not one of target_keywords", keyword)
+		}
+		validatePositive(errs, field, bids[keyword])
+	}
+}
+
+func targetedKeywords(keywords []string) map[string]bool {
+	targeted := make(map[string]bool, len(keywords))
+	for _, keyword := range keywords {
+		targeted[keyword] = true
+	}
+	return targeted
+}
+
+// Returns the keys of a map sorted, so problems are reported in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
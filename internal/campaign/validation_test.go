@@ -0,0 +1,228 @@
+package campaign
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kriscampos/adserver/internal/frequency"
+)
+
+func TestPostCampaignRequestValidate(t *testing.T) {
+	now := time.Now()
+	start, end := now.Add(-time.Hour).Unix(), now.Add(time.Hour).Unix()
+	testcases := []struct {
+		name     string
+		input    *PostCampaignRequest
+		expected []FieldError
+	}{
+		{
+			name: "Valid request",
+			input: &PostCampaignRequest{
+				StartTimestamp: start,
+				EndTimestamp:   end,
+				TargetKeywords: []string{"cat", "dog"},
+				MaxImpression:  10,
+				CPM:            2.5,
+			},
+			expected: nil,
+		},
+		{
+			name:  "Missing fields",
+			input: &PostCampaignRequest{},
+			expected: []FieldError{
+				{Field: "start_timestamp", Code: CodeRequired},
+				{Field: "end_timestamp", Code: CodeRequired},
+				{Field: "target_keywords", Code: CodeRequired},
+				{Field: "max_impression", Code: CodeRequired},
+				{Field: "cpm", Code: CodeRequired},
+			},
+		},
+		{
+			name: "Every field is invalid",
+			input: &PostCampaignRequest{
+				StartTimestamp:    now.Add(-time.Hour).Unix(),
+				EndTimestamp:      now.Add(-2 * time.Hour).Unix(),
+				TargetKeywords:    []string{"cat", " ", "cat", "\x00house"},
+				MaxImpression:     -1,
+				CPM:               -0.5,
+				TotalBudget:       -10,
+				DailyBudget:       -1,
+				Timezone:          "Mars/Olympus_Mons",
+				Pacing:            "whenever",
+				Tier:              "gold",
+				FrequencyCaps:     []frequency.Cap{{Impressions: 0, WindowSeconds: 86400}, {Impressions: 3, WindowSeconds: 31 * 86400}},
+				KeywordMatchTypes: map[string]MatchType{"cat": "fuzzy", "bird": MatchExact},
+				NegativeKeywords:  []string{"dog", " ", "free\x00play"},
+				KeywordBids:       map[string]float64{"cat": 0, "fish": 2.0},
+			},
+			expected: []FieldError{
+				{Field: "end_timestamp", Code: CodeInPast},
+				{Field: "end_timestamp", Code: CodeNotAfterStart},
+				{Field: "target_keywords[1]", Code: CodeEmptyKeyword},
+				{Field: "target_keywords[2]", Code: CodeDuplicateKeyword},
+				{Field: "target_keywords[3]", Code: CodeControlCharacter},
+				{Field: "max_impression", Code: CodeNotPositive},
+				{Field: "cpm", Code: CodeNotPositive},
+				{Field: "total_budget", Code: CodeNegative},
+				{Field: "daily_budget", Code: CodeNegative},
+				{Field: "timezone", Code: CodeUnknownTimezone},
+				{Field: "pacing", Code: CodeUnknownPacing},
+				{Field: "tier", Code: CodeUnknownTier},
+				{Field: "frequency_caps[0].impressions", Code: CodeNotPositive},
+				{Field: "frequency_caps[1].window_seconds", Code: CodeWindowTooLong},
+				{Field: `keyword_match_types["bird"]`, Code: CodeUnknownKeyword},
+				{Field: `keyword_match_types["cat"]`, Code: CodeUnknownMatchType},
+				{Field: "negative_keywords[1]", Code: CodeEmptyKeyword},
+				{Field: "negative_keywords[2]", Code: CodeControlCharacter},
+				{Field: `keyword_bids["cat"]`, Code: CodeNotPositive},
+				{Field: `keyword_bids["fish"]`, Code: CodeUnknownKeyword},
+			},
+		},
+		{
+			name: "Budgets below one impression at the highest bid",
+			input: &PostCampaignRequest{
+				StartTimestamp: start,
+				EndTimestamp:   end,
+				TargetKeywords: []string{"cat", "dog"},
+				MaxImpression:  10,
+				CPM:            1.0,
+				TotalBudget:    0.0001,
+				DailyBudget:    0.002,
+				KeywordBids:    map[string]float64{"dog": 3.0},
+			},
+			expected: []FieldError{
+				{Field: "total_budget", Code: CodeBelowPrice},
+				{Field: "daily_budget", Code: CodeBelowPrice},
+			},
+		},
+		{
+			name: "Start after end",
+			input: &PostCampaignRequest{
+				StartTimestamp: now.Add(3 * time.Hour).Unix(),
+				EndTimestamp:   now.Add(2 * time.Hour).Unix(),
+				TargetKeywords: []string{"cat"},
+				MaxImpression:  10,
+				CPM:            2.5,
+			},
+			expected: []FieldError{
+				{Field: "end_timestamp", Code: CodeNotAfterStart},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertFieldErrors(t, tc.input.Validate(now), tc.expected)
+		})
+	}
+}
+
+func TestPatchCampaignRequestValidate(t *testing.T) {
+	now := time.Now()
+	current := &Campaign{
+		StartTimestamp: now.Add(-time.Hour),
+		EndTimestamp:   now.Add(time.Hour),
+		TargetKeywords: []string{"cat"},
+		MaxImpression:  10,
+		CPM:            2.5,
+	}
+	zero, pastEnd := 0, now.Add(-30*time.Minute)
+	testcases := []struct {
+		name     string
+		input    *PatchCampaignRequest
+		updated  func(c Campaign) Campaign
+		expected []FieldError
+	}{
+		{
+			name:     "Empty patch",
+			input:    &PatchCampaignRequest{},
+			updated:  func(c Campaign) Campaign { return c },
+			expected: nil,
+		},
+		{
+			name: "Unchanged fields are not checked",
+			input: &PatchCampaignRequest{
+				TargetKeywords: []string{"dog"},
+			},
+			updated: func(c Campaign) Campaign {
+				c.EndTimestamp = pastEnd
+				c.TargetKeywords = []string{"dog"}
+				return c
+			},
+			expected: nil,
+		},
+		{
+			name: "Match type of a keyword that is no longer targeted",
+			input: &PatchCampaignRequest{
+				TargetKeywords: []string{"dog"},
+			},
+			updated: func(c Campaign) Campaign {
+				c.TargetKeywords = []string{"dog"}
+				c.KeywordMatchTypes = map[string]MatchType{"cat": MatchPhrase}
+				return c
+			},
+			expected: []FieldError{
+				{Field: `keyword_match_types["cat"]`, Code: CodeUnknownKeyword},
+			},
+		},
+		{
+			name: "Raised bid outgrows the budget",
+			input: &PatchCampaignRequest{
+				KeywordBids: map[string]float64{"cat": 20.0},
+			},
+			updated: func(c Campaign) Campaign {
+				c.TotalBudget = 0.01
+				c.KeywordBids = map[string]float64{"cat": 20.0}
+				return c
+			},
+			expected: []FieldError{
+				{Field: "total_budget", Code: CodeBelowPrice},
+			},
+		},
+		{
+			name: "Invalid changes",
+			input: &PatchCampaignRequest{
+				EndTimestamp:   new(int64),
+				TargetKeywords: []string{},
+				MaxImpression:  &zero,
+			},
+			updated: func(c Campaign) Campaign {
+				c.EndTimestamp = pastEnd
+				c.TargetKeywords = []string{}
+				c.MaxImpression = zero
+				return c
+			},
+			expected: []FieldError{
+				{Field: "end_timestamp", Code: CodeInPast},
+				{Field: "target_keywords", Code: CodeRequired},
+				{Field: "max_impression", Code: CodeNotPositive},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			updated := tc.updated(*current)
+			assertFieldErrors(t, tc.input.Validate(&updated, now), tc.expected)
+		})
+	}
+}
+
+// Compares the fields and codes of a validation error, ignoring messages.
+func assertFieldErrors(t *testing.T, err error, expected []FieldError) {
+	t.Helper()
+	if expected == nil {
+		if err != nil {
+			t.Errorf("Expected no error but Found: %v", err)
+		}
+		return
+	}
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a *ValidationError but Found: %v", err)
+	}
+	if diff := cmp.Diff(expected, validationErr.Fields, cmpopts.IgnoreFields(FieldError{}, "Message")); diff != "" {
+		t.Errorf("Field errors mismatch (-expected +found):\n%s", diff)
+	}
+}
@@ -0,0 +1,47 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_Advance(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	f := NewFake(start)
+	f.Advance(90 * time.Minute)
+	if expected := start.Add(90 * time.Minute); !f.Now().Equal(expected) {
+		t.Errorf("Expected %s but Found %s", expected, f.Now())
+	}
+}
+
+func TestFake_Ticker(t *testing.T) {
+	start := time.Unix(1684616602, 0)
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Second)
+
+	f.Advance(500 * time.Millisecond)
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("Ticker fired before its period elapsed at %s", tick)
+	default:
+	}
+
+	// Ticks that are not received are dropped, like with time.Ticker.
+	f.Advance(3 * time.Second)
+	if tick := <-ticker.C(); !tick.Equal(start.Add(time.Second)) {
+		t.Errorf("Expected first tick at %s but Found %s", start.Add(time.Second), tick)
+	}
+	select {
+	case tick := <-ticker.C():
+		t.Errorf("Expected later ticks to be dropped but Found %s", tick)
+	default:
+	}
+
+	ticker.Stop()
+	f.Advance(time.Second)
+	select {
+	case tick := <-ticker.C():
+		t.Errorf("Stopped ticker fired at %s", tick)
+	default:
+	}
+}
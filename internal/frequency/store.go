@@ -0,0 +1,443 @@
+package frequency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kriscampos/adserver/internal/clock"
+	"github.com/kriscampos/adserver/internal/persist"
+)
+
+const (
+	// Longest window a cap can count exposures over.
+	MaxWindow = 30 * 24 * time.Hour
+	// Most users a store remembers exposures of by default.
+	DefaultMaxUsers = 1000000
+)
+
+var ErrInvalidCap = errors.New("invalid frequency cap")
+
+// Reasons a field of a cap is rejected.
+var (
+	ErrNotPositive   = errors.New("must be greater than zero")
+	ErrWindowTooLong = fmt.Errorf("must be at most %d", int64(MaxWindow/time.Second))
+)
+
+// Cap limits how many times a user is shown ads within a sliding window, e.g. 3
+// impressions every 86400 seconds.
+type Cap struct {
+	Impressions   int   `json:"impressions"`
+	WindowSeconds int64 `json:"window_seconds"`
+}
+
+func (c Cap) Window() time.Duration {
+	return time.Duration(c.WindowSeconds) * time.Second
+}
+
+// A rejected field of one of several caps.
+type FieldError struct {
+	Index int
+	// "impressions" or "window_seconds".
+	Field string
+	// ErrNotPositive or ErrWindowTooLong.
+	Err error
+}
+
+// Every problem found with a list of caps. It wraps ErrInvalidCap.
+type CapsError struct {
+	Fields []FieldError
+}
+
+func (e *CapsError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = fmt.Sprintf("caps[%d].%s %v", f.Index, f.Field, f.Err)
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalidCap, strings.Join(messages, "; "))
+}
+
+func (e *CapsError) Unwrap() error {
+	return ErrInvalidCap
+}
+
+// Returns a *CapsError listing every problem with caps, or nil if there are
+// none.
+func ValidateCaps(caps []Cap) error {
+	errs := &CapsError{}
+	for i, c := range caps {
+		if c.Impressions <= 0 {
+			errs.Fields = append(errs.Fields, FieldError{Index: i, Field: "impressions", Err: ErrNotPositive})
+		}
+		if c.WindowSeconds <= 0 {
+			errs.Fields = append(errs.Fields, FieldError{Index: i, Field: "window_seconds", Err: ErrNotPositive})
+		} else if c.Window() > MaxWindow {
+			errs.Fields = append(errs.Fields, FieldError{Index: i, Field: "window_seconds", Err: ErrWindowTooLong})
+		}
+	}
+	if len(errs.Fields) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// An ad decision counted against a user's frequency caps.
+type Exposure struct {
+	UserID     string
+	CampaignID int
+	Advertiser string
+	At         time.Time
+	// Scopes the exposure was counted in.
+	scopes []string
+}
+
+// Store counts how often each user was shown each campaign and each
+// advertiser's campaigns, and holds the frequency caps of advertisers. It is
+// safe for concurrent use.
+//
+// Exposures are counted when the ad decision is made, whether or not its
+// impression is recorded, so users are never shown a campaign more often than
+// its caps allow.
+//
+// Only exposures to capped campaigns and advertisers are counted, and only for
+// as long as the longest of their caps looks back. Caps therefore only count
+// exposures made after they were set. Once more than the maximum number of
+// users have been exposed, the users exposed least recently are forgotten.
+type Store struct {
+	mu       sync.Mutex
+	clock    clock.Clock
+	maxUsers int
+	path     string
+	data     storeData
+	sweeper  *persist.Periodic
+}
+
+// What is persisted of a store.
+type storeData struct {
+	// Times of exposures per user and then per campaign ("c:<id>") or
+	// advertiser ("a:<name>"), oldest first.
+	Exposures      map[string]map[string][]time.Time `json:"exposures"`
+	AdvertiserCaps map[string][]Cap                  `json:"advertiser_caps"`
+	// Longest cap window of each scope when it was last counted in, which its
+	// exposures are kept for.
+	WindowSeconds map[string]int64 `json:"window_seconds"`
+}
+
+// Configures optional behavior of a Store.
+type Option func(*Store)
+
+func WithClock(c clock.Clock) Option {
+	return func(s *Store) {
+		s.clock = c
+	}
+}
+
+func WithMaxUsers(maxUsers int) Option {
+	return func(s *Store) {
+		s.maxUsers = maxUsers
+	}
+}
+
+// Creates a store that is only kept in memory.
+func NewStore(opts ...Option) *Store {
+	s := &Store{
+		clock:    clock.New(),
+		maxUsers: DefaultMaxUsers,
+		data: storeData{
+			Exposures:      make(map[string]map[string][]time.Time),
+			AdvertiserCaps: make(map[string][]Cap),
+			WindowSeconds:  make(map[string]int64),
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Creates a store that is saved to path, loading what it held before if the
+// file exists.
+func OpenStore(path string, opts ...Option) (*Store, error) {
+	s := NewStore(opts...)
+	s.path = path
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &s.data); err != nil {
+		return nil, fmt.Errorf("reading frequency store %s: %w", path, err)
+	}
+	if s.data.Exposures == nil {
+		s.data.Exposures = make(map[string]map[string][]time.Time)
+	}
+	if s.data.AdvertiserCaps == nil {
+		s.data.AdvertiserCaps = make(map[string][]Cap)
+	}
+	if s.data.WindowSeconds == nil {
+		s.data.WindowSeconds = make(map[string]int64)
+	}
+	return s, nil
+}
+
+// Begins forgetting old exposures and saving the store every minute. Stop saves
+// it one last time.
+func (s *Store) Start() {
+	s.sweeper = persist.Every(s.clock, time.Minute, func() {
+		s.sweep()
+		if err := s.Save(); err != nil {
+			log.Printf("Failed to save frequency store: %v\n", err)
+		}
+	})
+}
+
+func (s *Store) Stop() error {
+	s.sweeper.Stop()
+	return s.Save()
+}
+
+// Writes the store to its file, replacing the previous version. Stores without
+// a file are not saved.
+func (s *Store) Save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.mu.Lock()
+	contents, err := json.Marshal(&s.data)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return persist.WriteFile(s.path, contents)
+}
+
+// Replaces the caps that apply to all of an advertiser's campaigns together.
+func (s *Store) SetAdvertiserCaps(advertiser string, caps []Cap) error {
+	if err := ValidateCaps(caps); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(caps) == 0 {
+		delete(s.data.AdvertiserCaps, advertiser)
+		return nil
+	}
+	s.data.AdvertiserCaps[advertiser] = append([]Cap(nil), caps...)
+	return nil
+}
+
+func (s *Store) AdvertiserCaps(advertiser string) []Cap {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Cap{}, s.data.AdvertiserCaps[advertiser]...)
+}
+
+// Counts an exposure of the user to a campaign if neither the campaign's caps
+// nor its advertiser's caps have been reached. Requests without a user are
+// never capped and not counted, and neither are exposures to campaigns without
+// any caps, so the returned exposure is nil for them.
+func (s *Store) Reserve(userID string, campaignID int, campaignCaps []Cap, advertiser string) (*Exposure, bool) {
+	if userID == "" {
+		return nil, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	if !s.allowed(userID, campaignID, campaignCaps, advertiser, now) {
+		return nil, false
+	}
+
+	capped := make(map[string][]Cap)
+	if len(campaignCaps) > 0 {
+		capped[campaignKey(campaignID)] = campaignCaps
+	}
+	if caps := s.data.AdvertiserCaps[advertiser]; advertiser != "" && len(caps) > 0 {
+		capped[advertiserKey(advertiser)] = caps
+	}
+	if len(capped) == 0 {
+		return nil, true
+	}
+	scopes := s.data.Exposures[userID]
+	if scopes == nil {
+		if len(s.data.Exposures) >= s.maxUsers {
+			s.evict()
+		}
+		scopes = make(map[string][]time.Time)
+		s.data.Exposures[userID] = scopes
+	}
+	exposure := &Exposure{UserID: userID, CampaignID: campaignID, Advertiser: advertiser, At: now}
+	for scope, caps := range capped {
+		window := longestWindow(caps)
+		s.data.WindowSeconds[scope] = int64(window / time.Second)
+		scopes[scope] = append(since(scopes[scope], now.Add(-window)), now)
+		exposure.scopes = append(exposure.scopes, scope)
+	}
+	return exposure, true
+}
+
+// Determines if the user could be shown the campaign without counting an
+// exposure.
+func (s *Store) Allowed(userID string, campaignID int, campaignCaps []Cap, advertiser string) bool {
+	if userID == "" {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.allowed(userID, campaignID, campaignCaps, advertiser, s.clock.Now())
+}
+
+func (s *Store) allowed(userID string, campaignID int, campaignCaps []Cap, advertiser string, now time.Time) bool {
+	scopes := s.data.Exposures[userID]
+	if !withinCaps(scopes[campaignKey(campaignID)], campaignCaps, now) {
+		return false
+	}
+	return advertiser == "" || withinCaps(scopes[advertiserKey(advertiser)], s.data.AdvertiserCaps[advertiser], now)
+}
+
+// Stops counting an exposure, e.g. when the campaign could not be served after
+// all. Nil exposures are ignored.
+func (s *Store) Forget(e *Exposure) {
+	if e == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scopes, ok := s.data.Exposures[e.UserID]
+	if !ok {
+		return
+	}
+	for _, scope := range e.scopes {
+		forget(scopes, scope, e.At)
+	}
+	if len(scopes) == 0 {
+		delete(s.data.Exposures, e.UserID)
+	}
+}
+
+// Forgets exposures older than the longest window of their scope, users
+// without any exposures left and the windows of scopes no user was exposed in.
+func (s *Store) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	counted := make(map[string]bool)
+	for userID, scopes := range s.data.Exposures {
+		for scope, times := range scopes {
+			kept := since(times, now.Add(-s.window(scope)))
+			if len(kept) == 0 {
+				delete(scopes, scope)
+				continue
+			}
+			if len(kept) < len(times) {
+				scopes[scope] = append([]time.Time(nil), kept...)
+			}
+			counted[scope] = true
+		}
+		if len(scopes) == 0 {
+			delete(s.data.Exposures, userID)
+		}
+	}
+	for scope := range s.data.WindowSeconds {
+		if !counted[scope] {
+			delete(s.data.WindowSeconds, scope)
+		}
+	}
+}
+
+// Returns how long exposures in a scope are kept, which is MaxWindow for
+// scopes counted before windows were recorded.
+func (s *Store) window(scope string) time.Duration {
+	if seconds, ok := s.data.WindowSeconds[scope]; ok {
+		return time.Duration(seconds) * time.Second
+	}
+	return MaxWindow
+}
+
+// Forgets the users exposed least recently, keeping nine tenths of the
+// maximum so users are not evicted one at a time.
+func (s *Store) evict() {
+	type user struct {
+		id     string
+		latest time.Time
+	}
+	users := make([]user, 0, len(s.data.Exposures))
+	for id, scopes := range s.data.Exposures {
+		u := user{id: id}
+		for _, times := range scopes {
+			if last := times[len(times)-1]; last.After(u.latest) {
+				u.latest = last
+			}
+		}
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].latest.Before(users[j].latest)
+	})
+	for _, u := range users[:len(users)-s.maxUsers*9/10] {
+		delete(s.data.Exposures, u.id)
+	}
+}
+
+// Determines if another exposure is allowed by every cap.
+func withinCaps(times []time.Time, caps []Cap, now time.Time) bool {
+	for _, c := range caps {
+		if countSince(times, now.Add(-c.Window())) >= c.Impressions {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of times after from.
+func countSince(times []time.Time, from time.Time) int {
+	return len(since(times, from))
+}
+
+// Returns the times after from.
+func since(times []time.Time, from time.Time) []time.Time {
+	return times[sort.Search(len(times), func(i int) bool { return times[i].After(from) }):]
+}
+
+// Returns the longest window of caps.
+func longestWindow(caps []Cap) time.Duration {
+	var longest time.Duration
+	for _, c := range caps {
+		if c.Window() > longest {
+			longest = c.Window()
+		}
+	}
+	return longest
+}
+
+// Removes the latest time equal to at.
+func forget(scopes map[string][]time.Time, scope string, at time.Time) {
+	times := scopes[scope]
+	for i := len(times) - 1; i >= 0; i-- {
+		if times[i].Equal(at) {
+			times = append(times[:i], times[i+1:]...)
+			break
+		}
+	}
+	if len(times) == 0 {
+		delete(scopes, scope)
+	} else {
+		scopes[scope] = times
+	}
+}
+
+func campaignKey(campaignID int) string {
+	return "c:" + strconv.Itoa(campaignID)
+}
+
+func advertiserKey(advertiser string) string {
+	return "a:" + advertiser
+}
@@ -0,0 +1,204 @@
+package frequency
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kriscampos/adserver/internal/clock"
+)
+
+func TestReserve_SlidingWindow(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(1684616602, 0))
+	s := NewStore(WithClock(fakeClock))
+	caps := []Cap{
+		{Impressions: 2, WindowSeconds: 60 * 60},
+		{Impressions: 3, WindowSeconds: 24 * 60 * 60},
+	}
+
+	testcases := []struct {
+		name     string
+		advance  time.Duration
+		expected bool
+	}{
+		{name: "First exposure", expected: true},
+		{name: "Second exposure in the hour", advance: 10 * time.Minute, expected: true},
+		{name: "Third exposure in the hour", advance: 10 * time.Minute, expected: false},
+		{name: "First exposure slid out of the hour", advance: 41 * time.Minute, expected: true},
+		{name: "Daily cap reached", advance: 2 * time.Hour, expected: false},
+		{name: "First exposure slid out of the day", advance: 22 * time.Hour, expected: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClock.Advance(tc.advance)
+			if _, actual := s.Reserve("alice", 1, caps, ""); actual != tc.expected {
+				t.Errorf("Expected %t but Found %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestReserve_AdvertiserCaps(t *testing.T) {
+	s := NewStore()
+	if err := s.SetAdvertiserCaps("acme", []Cap{{Impressions: 2, WindowSeconds: 60}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reserve := func(userID string, campaignID int, advertiser string) bool {
+		_, ok := s.Reserve(userID, campaignID, nil, advertiser)
+		return ok
+	}
+	actual := []bool{
+		reserve("alice", 1, "acme"),
+		reserve("alice", 2, "acme"),
+		reserve("alice", 3, "acme"),
+		reserve("alice", 4, "other"),
+		reserve("bob", 3, "acme"),
+		reserve("", 3, "acme"),
+	}
+	expected := []bool{true, true, false, true, true, true}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("Unexpected reservations (-want +got):\n%s", diff)
+	}
+}
+
+func TestForget(t *testing.T) {
+	s := NewStore()
+	caps := []Cap{{Impressions: 1, WindowSeconds: 60}}
+	exposure, ok := s.Reserve("alice", 1, caps, "acme")
+	if !ok {
+		t.Fatal("Expected first exposure to be allowed.")
+	}
+	s.Forget(exposure)
+	if _, ok := s.Reserve("alice", 1, caps, "acme"); !ok {
+		t.Error("Expected forgotten exposure not to count.")
+	}
+	s.Forget(nil)
+}
+
+func TestSweep(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(1684616602, 0))
+	s := NewStore(WithClock(fakeClock))
+	hourly := []Cap{{Impressions: 5, WindowSeconds: 60 * 60}}
+	daily := []Cap{{Impressions: 1, WindowSeconds: 60}, {Impressions: 5, WindowSeconds: 24 * 60 * 60}}
+	s.Reserve("alice", 1, hourly, "")
+	fakeClock.Advance(30 * time.Minute)
+	s.Reserve("bob", 1, hourly, "")
+	s.Reserve("carol", 2, daily, "")
+	fakeClock.Advance(31 * time.Minute)
+
+	s.sweep()
+	var actual []string
+	for userID := range s.data.Exposures {
+		actual = append(actual, userID)
+	}
+	if diff := cmp.Diff([]string{"bob", "carol"}, actual, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("Users mismatch (-want +got):\n%s", diff)
+	}
+
+	fakeClock.Advance(24 * time.Hour)
+	s.sweep()
+	if len(s.data.Exposures) != 0 || len(s.data.WindowSeconds) != 0 {
+		t.Errorf("Expected everything to be forgotten but Found %v and %v", s.data.Exposures, s.data.WindowSeconds)
+	}
+}
+
+func TestReserve_Uncapped(t *testing.T) {
+	s := NewStore()
+	s.SetAdvertiserCaps("acme", []Cap{{Impressions: 1, WindowSeconds: 60}})
+	for i := 0; i < 10; i++ {
+		exposure, ok := s.Reserve("alice", 1, nil, "globex")
+		if !ok || exposure != nil {
+			t.Fatalf("Expected an uncounted exposure but Found %v, %t", exposure, ok)
+		}
+	}
+	if len(s.data.Exposures) != 0 {
+		t.Errorf("Expected no exposures to be kept but Found %v", s.data.Exposures)
+	}
+
+	s.Reserve("alice", 1, nil, "acme")
+	var scopes []string
+	for scope := range s.data.Exposures["alice"] {
+		scopes = append(scopes, scope)
+	}
+	if diff := cmp.Diff([]string{"a:acme"}, scopes); diff != "" {
+		t.Errorf("Expected only the advertiser exposure to be kept (-want +got):\n%s", diff)
+	}
+}
+
+func TestReserve_MaxUsers(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(1684616602, 0))
+	s := NewStore(WithClock(fakeClock), WithMaxUsers(10))
+	caps := []Cap{{Impressions: 1, WindowSeconds: 24 * 60 * 60}}
+	for i := 0; i < 10; i++ {
+		s.Reserve(strconv.Itoa(i), 1, caps, "")
+		fakeClock.Advance(time.Minute)
+	}
+	s.Reserve("10", 1, caps, "")
+	if len(s.data.Exposures) != 10 {
+		t.Errorf("Expected 10 users but Found %d", len(s.data.Exposures))
+	}
+	if _, ok := s.data.Exposures["0"]; ok {
+		t.Error("Expected the user exposed least recently to be forgotten.")
+	}
+	if _, ok := s.data.Exposures["10"]; !ok {
+		t.Error("Expected the new user to be kept.")
+	}
+}
+
+func TestSetAdvertiserCaps_Invalid(t *testing.T) {
+	testcases := []struct {
+		name     string
+		input    Cap
+		expected []FieldError
+	}{
+		{name: "No impressions", input: Cap{WindowSeconds: 60}, expected: []FieldError{{Field: "impressions", Err: ErrNotPositive}}},
+		{name: "No window", input: Cap{Impressions: 1}, expected: []FieldError{{Field: "window_seconds", Err: ErrNotPositive}}},
+		{name: "Window too long", input: Cap{Impressions: 1, WindowSeconds: int64(MaxWindow/time.Second) + 1}, expected: []FieldError{{Field: "window_seconds", Err: ErrWindowTooLong}}},
+		{name: "Every problem", input: Cap{Impressions: -1}, expected: []FieldError{{Field: "impressions", Err: ErrNotPositive}, {Field: "window_seconds", Err: ErrNotPositive}}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewStore().SetAdvertiserCaps("acme", []Cap{tc.input})
+			if !errors.Is(err, ErrInvalidCap) {
+				t.Errorf("Expected ErrInvalidCap but Found %v", err)
+			}
+			var capsErr *CapsError
+			if !errors.As(err, &capsErr) {
+				t.Fatalf("Expected a *CapsError but Found %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, capsErr.Fields, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Field mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOpenStore_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frequency.json")
+	caps := []Cap{{Impressions: 1, WindowSeconds: 60 * 60}}
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error opening store: %v", err)
+	}
+	s.SetAdvertiserCaps("acme", caps)
+	s.Reserve("alice", 1, nil, "acme")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Unexpected error saving store: %v", err)
+	}
+
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error reopening store: %v", err)
+	}
+	if diff := cmp.Diff(caps, s.AdvertiserCaps("acme")); diff != "" {
+		t.Errorf("Advertiser caps changed after reopening (-want +got):\n%s", diff)
+	}
+	if _, ok := s.Reserve("alice", 2, nil, "acme"); ok {
+		t.Error("Expected exposures to be capped after reopening.")
+	}
+}
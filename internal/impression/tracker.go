@@ -0,0 +1,196 @@
+package impression
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kriscampos/adserver/internal/clock"
+)
+
+// How long an impression token can be redeemed for by default.
+const DefaultTTL = 15 * time.Minute
+
+var (
+	ErrUnknownToken = errors.New("unknown impression token")
+	ErrTokenUsed    = errors.New("impression token was already used")
+	ErrTokenExpired = errors.New("impression token expired")
+)
+
+// Details of the ad decision request a token was issued for.
+type Request struct {
+	Keywords []string
+	ClientIP string
+	UserID   string
+}
+
+// An ad decision that an impression can be recorded for, at the price the
+// impression is charged at.
+type Decision struct {
+	Token      string
+	CampaignID int
+	Price      float64
+	Request    Request
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+}
+
+type record struct {
+	decision Decision
+	redeemed bool
+}
+
+// Tracker issues a single-use impression token for every ad decision and
+// redeems it when the impression is recorded. It is safe for concurrent use.
+//
+// Tokens are remembered for one TTL after they expire so late or repeated
+// redemptions can be told apart from made up tokens, and are forgotten after.
+type Tracker struct {
+	mu       sync.Mutex
+	clock    clock.Clock
+	ttl      time.Duration
+	onExpire func(*Decision)
+	records  map[string]*record
+	// Records in the order they were issued, which is also the order they
+	// expire in.
+	issued []*record
+	// The issued records that have not expired yet.
+	unexpired     []*record
+	expireTicker  clock.Ticker
+	closeExpiring chan bool
+}
+
+// Configures optional behavior of a Tracker.
+type Option func(*Tracker)
+
+func WithClock(c clock.Clock) Option {
+	return func(t *Tracker) {
+		t.clock = c
+	}
+}
+
+// Sets how long a token can be redeemed for after it is issued.
+func WithTTL(ttl time.Duration) Option {
+	return func(t *Tracker) {
+		t.ttl = ttl
+	}
+}
+
+// Sets a function that is called with every decision whose token expires
+// without being redeemed, e.g. to release what was reserved for it.
+func WithExpiryHandler(onExpire func(*Decision)) Option {
+	return func(t *Tracker) {
+		t.onExpire = onExpire
+	}
+}
+
+func NewTracker(opts ...Option) *Tracker {
+	t := &Tracker{
+		clock:    clock.New(),
+		ttl:      DefaultTTL,
+		onExpire: func(*Decision) {},
+		records:  make(map[string]*record),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Begins expiring tokens in the background. Tokens are also expired whenever
+// one is issued or redeemed.
+func (t *Tracker) Start() {
+	t.expireTicker = t.clock.NewTicker(time.Second)
+	t.closeExpiring = make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-t.expireTicker.C():
+				t.expire()
+			case <-t.closeExpiring:
+				t.expireTicker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (t *Tracker) Stop() {
+	t.closeExpiring <- true
+}
+
+// Issues a new token for a decision to show the given campaign at a price.
+func (t *Tracker) Issue(campaignID int, price float64, request Request) *Decision {
+	t.expire()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock.Now()
+	r := &record{
+		decision: Decision{
+			Token:      uuid.NewString(),
+			CampaignID: campaignID,
+			Price:      price,
+			Request: Request{
+				Keywords: append([]string(nil), request.Keywords...),
+				ClientIP: request.ClientIP,
+				UserID:   request.UserID,
+			},
+			IssuedAt:  now,
+			ExpiresAt: now.Add(t.ttl),
+		},
+	}
+	t.records[r.decision.Token] = r
+	t.issued = append(t.issued, r)
+	t.unexpired = append(t.unexpired, r)
+	decision := r.decision
+	return &decision
+}
+
+// Redeems a token and returns the decision it was issued for. Fails with
+// ErrUnknownToken, ErrTokenUsed or ErrTokenExpired when the token cannot be
+// redeemed.
+func (t *Tracker) Redeem(token string) (*Decision, error) {
+	t.expire()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.records[token]
+	switch {
+	case !ok:
+		return nil, ErrUnknownToken
+	case r.redeemed:
+		return nil, ErrTokenUsed
+	case !t.clock.Now().Before(r.decision.ExpiresAt):
+		return nil, ErrTokenExpired
+	}
+	r.redeemed = true
+	decision := r.decision
+	return &decision, nil
+}
+
+// Hands decisions whose tokens expired unredeemed to the expiry handler and
+// forgets tokens that expired more than one TTL ago.
+func (t *Tracker) expire() {
+	t.mu.Lock()
+	now := t.clock.Now()
+	var expired []*Decision
+	for len(t.unexpired) > 0 && !now.Before(t.unexpired[0].decision.ExpiresAt) {
+		if r := t.unexpired[0]; !r.redeemed {
+			decision := r.decision
+			expired = append(expired, &decision)
+		}
+		t.unexpired[0] = nil
+		t.unexpired = t.unexpired[1:]
+	}
+	for len(t.issued) > 0 && !now.Before(t.issued[0].decision.ExpiresAt.Add(t.ttl)) {
+		delete(t.records, t.issued[0].decision.Token)
+		t.issued[0] = nil
+		t.issued = t.issued[1:]
+	}
+	t.mu.Unlock()
+
+	// The handler runs unlocked so it is free to take its own locks.
+	for _, decision := range expired {
+		t.onExpire(decision)
+	}
+}
@@ -0,0 +1,151 @@
+package impression
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kriscampos/adserver/internal/clock"
+)
+
+func TestRedeem(t *testing.T) {
+	testcases := []struct {
+		name        string
+		redeemFunc  func(tracker *Tracker, fakeClock *clock.Fake, token string) (*Decision, error)
+		expectedErr error
+	}{
+		{
+			name: "Redeem within TTL",
+			redeemFunc: func(tracker *Tracker, fakeClock *clock.Fake, token string) (*Decision, error) {
+				fakeClock.Advance(time.Minute - time.Second)
+				return tracker.Redeem(token)
+			},
+		},
+		{
+			name: "Redeem twice",
+			redeemFunc: func(tracker *Tracker, fakeClock *clock.Fake, token string) (*Decision, error) {
+				tracker.Redeem(token)
+				return tracker.Redeem(token)
+			},
+			expectedErr: ErrTokenUsed,
+		},
+		{
+			name: "Redeem after TTL",
+			redeemFunc: func(tracker *Tracker, fakeClock *clock.Fake, token string) (*Decision, error) {
+				fakeClock.Advance(time.Minute)
+				return tracker.Redeem(token)
+			},
+			expectedErr: ErrTokenExpired,
+		},
+		{
+			name: "Redeem long after TTL",
+			redeemFunc: func(tracker *Tracker, fakeClock *clock.Fake, token string) (*Decision, error) {
+				fakeClock.Advance(2 * time.Minute)
+				return tracker.Redeem(token)
+			},
+			expectedErr: ErrUnknownToken,
+		},
+		{
+			name: "Redeem unknown token",
+			redeemFunc: func(tracker *Tracker, fakeClock *clock.Fake, token string) (*Decision, error) {
+				return tracker.Redeem("made-up")
+			},
+			expectedErr: ErrUnknownToken,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Unix(1684616602, 0)
+			fakeClock := clock.NewFake(now)
+			tracker := NewTracker(WithClock(fakeClock), WithTTL(time.Minute))
+			request := Request{Keywords: []string{"cat"}, ClientIP: "192.0.2.1"}
+			issued := tracker.Issue(7, 0.002, request)
+
+			decision, err := tc.redeemFunc(tracker, fakeClock, issued.Token)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected error %v but Found %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			expected := &Decision{
+				Token:      issued.Token,
+				CampaignID: 7,
+				Price:      0.002,
+				Request:    request,
+				IssuedAt:   now,
+				ExpiresAt:  now.Add(time.Minute),
+			}
+			if diff := cmp.Diff(expected, decision); diff != "" {
+				t.Errorf("Redeemed decision mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIssue_UniqueTokens(t *testing.T) {
+	tracker := NewTracker()
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token := tracker.Issue(0, 0, Request{}).Token
+		if seen[token] {
+			t.Fatalf("Token %s was issued twice.", token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestIssue_ForgetsExpiredTokens(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(1684616602, 0))
+	tracker := NewTracker(WithClock(fakeClock), WithTTL(time.Minute))
+	for i := 0; i < 10; i++ {
+		tracker.Issue(i, 0, Request{})
+	}
+	fakeClock.Advance(2 * time.Minute)
+	tracker.Issue(10, 0, Request{})
+	if len(tracker.records) != 1 || len(tracker.issued) != 1 {
+		t.Errorf("Expected only the latest token to be remembered but Found %d records and %d issued",
+			len(tracker.records), len(tracker.issued))
+	}
+}
+
+func TestExpiryHandler(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(1684616602, 0))
+	var expired []int
+	tracker := NewTracker(WithClock(fakeClock), WithTTL(time.Minute), WithExpiryHandler(func(d *Decision) {
+		expired = append(expired, d.CampaignID)
+	}))
+	redeemed := tracker.Issue(0, 0, Request{})
+	tracker.Issue(1, 0, Request{})
+	tracker.Redeem(redeemed.Token)
+
+	fakeClock.Advance(time.Minute)
+	tracker.Issue(2, 0, Request{})
+	fakeClock.Advance(time.Minute)
+	tracker.Issue(3, 0, Request{})
+	if diff := cmp.Diff([]int{1, 2}, expired); diff != "" {
+		t.Errorf("Expired decisions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExpiryHandler_StartedTracker(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(1684616602, 0))
+	expired := make(chan int, 1)
+	tracker := NewTracker(WithClock(fakeClock), WithTTL(time.Minute), WithExpiryHandler(func(d *Decision) {
+		expired <- d.CampaignID
+	}))
+	tracker.Start()
+	defer tracker.Stop()
+	tracker.Issue(7, 0, Request{})
+
+	fakeClock.Advance(time.Minute)
+	select {
+	case id := <-expired:
+		if id != 7 {
+			t.Errorf("Expected decision for campaign 7 to expire but Found %d", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the started tracker to expire the token.")
+	}
+}
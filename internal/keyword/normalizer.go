@@ -0,0 +1,126 @@
+package keyword
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Words dropped by DefaultConfig when stop word removal is enabled.
+var DefaultStopWords = []string{
+	"a", "an", "and", "at", "by", "for", "in", "of", "on", "or", "the", "to", "with",
+}
+
+// Steps applied by a Normalizer. Surrounding whitespace is always trimmed and
+// runs of whitespace between words are always collapsed. Control characters
+// are treated as whitespace, so normalized keywords never contain any.
+type Config struct {
+	// Applies Unicode NFKC normalization so equivalent code points compare equal.
+	Unicode bool
+	// Applies Unicode case folding.
+	CaseFold bool
+	// Reduces plural words to their singular form, e.g. "cats" to "cat".
+	Stem bool
+	// Words removed from keywords. Matched after every other step.
+	StopWords []string
+}
+
+// Returns the configuration used when none is given: Unicode normalization
+// and case folding without stemming or stop word removal.
+func DefaultConfig() Config {
+	return Config{
+		Unicode:  true,
+		CaseFold: true,
+	}
+}
+
+// Normalizer converts keywords into a canonical form so that keywords that
+// only differ by e.g. case or whitespace are treated as the same keyword.
+//
+// The same Normalizer must be used wherever keywords are compared.
+type Normalizer struct {
+	config    Config
+	stopWords map[string]bool
+}
+
+func NewNormalizer(config Config) *Normalizer {
+	n := &Normalizer{config: config, stopWords: make(map[string]bool)}
+	for _, word := range config.StopWords {
+		// Stop words go through the same steps as keywords so they are
+		// matched regardless of how they were written.
+		for _, normalized := range fields(n.normalizeText(word)) {
+			n.stopWords[normalized] = true
+		}
+	}
+	return n
+}
+
+// Returns the canonical form of a keyword, which is empty if nothing of the
+// keyword is left, e.g. when it only contains stop words.
+func (n *Normalizer) Normalize(keyword string) string {
+	words := fields(n.normalizeText(keyword))
+	kept := words[:0]
+	for _, word := range words {
+		if n.stopWords[word] {
+			continue
+		}
+		if n.config.Stem {
+			word = stem(word)
+		}
+		kept = append(kept, word)
+	}
+	return strings.Join(kept, " ")
+}
+
+// Normalizes every keyword, dropping empty results and duplicates while
+// preserving order.
+func (n *Normalizer) NormalizeAll(keywords []string) []string {
+	normalized := make([]string, 0, len(keywords))
+	seen := make(map[string]bool)
+	for _, keyword := range keywords {
+		k := n.Normalize(keyword)
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		normalized = append(normalized, k)
+	}
+	return normalized
+}
+
+func (n *Normalizer) normalizeText(text string) string {
+	if n.config.Unicode {
+		text = norm.NFKC.String(text)
+	}
+	if n.config.CaseFold {
+		// Casers keep state, so one is made per call rather than shared.
+		text = cases.Fold().String(text)
+	}
+	return text
+}
+
+// Splits text into words around whitespace and control characters.
+func fields(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	})
+}
+
+// Strips English plural suffixes following the rules of the S-stemmer
+// (Harman, 1991). Short words are left alone.
+func stem(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "ies") && !strings.HasSuffix(word, "eies") && !strings.HasSuffix(word, "aies"):
+		return strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "es") && !strings.HasSuffix(word, "aes") && !strings.HasSuffix(word, "ees") && !strings.HasSuffix(word, "oes"):
+		return strings.TrimSuffix(word, "s")
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "ss"):
+		return strings.TrimSuffix(word, "s")
+	}
+	return word
+}
@@ -0,0 +1,87 @@
+package keyword
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNormalize(t *testing.T) {
+	testcases := []struct {
+		name     string
+		config   Config
+		input    string
+		expected string
+	}{
+		{
+			name:     "Trims and collapses whitespace",
+			config:   Config{},
+			input:    "  red \t cat ",
+			expected: "red cat",
+		},
+		{
+			name:     "Control characters separate words",
+			config:   Config{},
+			input:    "\x00house\x7fcat",
+			expected: "house cat",
+		},
+		{
+			name:     "Case folding",
+			config:   Config{CaseFold: true},
+			input:    "Cat",
+			expected: "cat",
+		},
+		{
+			name:     "Case folding handles non-ASCII",
+			config:   Config{CaseFold: true},
+			input:    "STRASSE Straße",
+			expected: "strasse strasse",
+		},
+		{
+			name:     "Unicode normalization",
+			config:   Config{Unicode: true},
+			input:    "café ｃａｔ",
+			expected: "café cat",
+		},
+		{
+			name:     "Disabled steps are skipped",
+			config:   Config{},
+			input:    "Cats",
+			expected: "Cats",
+		},
+		{
+			name:     "Stemming",
+			config:   Config{Stem: true},
+			input:    "cats puppies boxes grass bus is",
+			expected: "cat puppy boxe grass bus is",
+		},
+		{
+			name:     "Stop words are matched after other steps",
+			config:   Config{CaseFold: true, StopWords: []string{"THE", "of"}},
+			input:    "The Cat of the Year",
+			expected: "cat year",
+		},
+		{
+			name:     "Only stop words",
+			config:   Config{StopWords: DefaultStopWords},
+			input:    "the and",
+			expected: "",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := NewNormalizer(tc.config).Normalize(tc.input); actual != tc.expected {
+				t.Errorf("Expected %q but Found %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNormalizeAll(t *testing.T) {
+	n := NewNormalizer(Config{CaseFold: true, Stem: true, StopWords: DefaultStopWords})
+	actual := n.NormalizeAll([]string{"Cat", "cat ", "cats", "the", "Dogs", ""})
+	expected := []string{"cat", "dog"}
+	if !cmp.Equal(expected, actual) {
+		t.Errorf("Expected: %+v Found: %+v", expected, actual)
+	}
+}
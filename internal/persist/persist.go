@@ -0,0 +1,66 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kriscampos/adserver/internal/clock"
+)
+
+// Writes contents to path through a temporary file in the same directory, so
+// the file at path always holds either its previous or its new contents.
+func WriteFile(path string, contents []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Periodic runs a task in the background on every tick of a clock's ticker,
+// e.g. to save state every minute.
+type Periodic struct {
+	ticker clock.Ticker
+	close  chan bool
+}
+
+// Begins running task every interval until Stop is called.
+func Every(c clock.Clock, interval time.Duration, task func()) *Periodic {
+	p := &Periodic{ticker: c.NewTicker(interval), close: make(chan bool)}
+	go func() {
+		for {
+			select {
+			case <-p.ticker.C():
+				task()
+			case <-p.close:
+				p.ticker.Stop()
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Stops running the task. A run that is in progress finishes first.
+func (p *Periodic) Stop() {
+	p.close <- true
+}
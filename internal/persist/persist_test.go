@@ -0,0 +1,55 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kriscampos/adserver/internal/clock"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	for _, contents := range []string{"first", "second"} {
+		if err := WriteFile(path, []byte(contents)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		actual, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(actual) != contents {
+			t.Errorf("Expected %q but Found %q", contents, actual)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected temporary files to be removed but Found %d files", len(entries))
+	}
+}
+
+func TestEvery(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(1684616602, 0))
+	runs := make(chan bool)
+	p := Every(fakeClock, time.Minute, func() { runs <- true })
+
+	fakeClock.Advance(time.Minute)
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the task to run after a minute.")
+	}
+	p.Stop()
+
+	fakeClock.Advance(time.Minute)
+	select {
+	case <-runs:
+		t.Error("Expected the task not to run after Stop.")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,133 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/kriscampos/adserver/internal/campaign"
+)
+
+// Makes binding validation report fields by their JSON names.
+func useJSONFieldNames() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
+}
+
+// Binds the JSON body of a request, aborting with a structured error when the
+// body is malformed or fails binding validation.
+func bindJSON(ctx *gin.Context, obj any) bool {
+	err := ctx.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+	ctx.Error(err)
+
+	var typeErr *json.UnmarshalTypeError
+	var bindingErrs validator.ValidationErrors
+	switch {
+	case errors.As(err, &typeErr):
+		abortWithValidationError(ctx, &campaign.ValidationError{Fields: []campaign.FieldError{{
+			Field:   typeErr.Field,
+			Code:    campaign.CodeInvalidType,
+			Message: "must be a " + typeErr.Type.String(),
+		}}})
+	case errors.As(err, &bindingErrs):
+		validationErr := &campaign.ValidationError{}
+		for _, fieldErr := range bindingErrs {
+			validationErr.Fields = append(validationErr.Fields, campaign.FieldError{
+				Field:   fieldErr.Field(),
+				Code:    campaign.ErrorCode(fieldErr.Tag()),
+				Message: "failed " + fieldErr.Tag() + " check",
+			})
+		}
+		abortWithValidationError(ctx, validationErr)
+	default:
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error":   "malformed_body",
+			"message": err.Error(),
+		})
+	}
+	return false
+}
+
+// Binds the query parameters of a request, aborting with a structured error
+// naming every parameter that could not be parsed.
+func bindQuery(ctx *gin.Context, obj any) bool {
+	err := ctx.ShouldBindQuery(obj)
+	if err == nil {
+		return true
+	}
+	ctx.Error(err)
+
+	// The parse error does not say which parameter failed, so each one is
+	// bound on its own.
+	validationErr := &campaign.ValidationError{}
+	query := ctx.Request.URL.Query()
+	objType := reflect.TypeOf(obj).Elem()
+	for _, name := range sortedKeys(query) {
+		probe := reflect.New(objType).Interface()
+		if binding.MapFormWithTag(probe, map[string][]string{name: query[name]}, "form") == nil {
+			continue
+		}
+		validationErr.Fields = append(validationErr.Fields, campaign.FieldError{
+			Field:   name,
+			Code:    campaign.CodeInvalidType,
+			Message: "must be a " + queryFieldType(objType, name).String(),
+		})
+	}
+	if len(validationErr.Fields) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error":   "malformed_query",
+			"message": err.Error(),
+		})
+		return false
+	}
+	abortWithValidationError(ctx, validationErr)
+	return false
+}
+
+// Returns the type of the struct field bound to a query parameter.
+func queryFieldType(t reflect.Type, name string) reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if tag, _, _ := strings.Cut(field.Tag.Get("form"), ","); tag == name {
+			if field.Type.Kind() == reflect.Pointer {
+				return field.Type.Elem()
+			}
+			return field.Type
+		}
+	}
+	return reflect.TypeOf("")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func abortWithValidationError(ctx *gin.Context, err *campaign.ValidationError) {
+	ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+		"error":  "validation_failed",
+		"fields": err.Fields,
+	})
+}
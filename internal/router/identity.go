@@ -0,0 +1,78 @@
+package router
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// First-party cookie holding the ID the server issued to a browser.
+	userIDCookie = "adserver_uid"
+	// Cookie that turns off all user-level tracking while it is present.
+	optOutCookie = "adserver_optout"
+	// How long the cookies last. Each response the user ID is sent with
+	// extends it.
+	cookieMaxAge = 390 * 24 * time.Hour
+	// Gin context key the request's Identity is stored under.
+	identityKey = "identity"
+)
+
+// Who an ad decision or impression request is for. Opted out requests have no
+// user ID.
+type Identity struct {
+	UserID   string
+	OptedOut bool
+}
+
+// Middleware that resolves the Identity of a request from its cookies. A
+// browser without a user ID cookie is issued a new ID, unless it opted out.
+func identify(ctx *gin.Context) {
+	if _, err := ctx.Cookie(optOutCookie); err == nil {
+		ctx.Set(identityKey, Identity{OptedOut: true})
+		ctx.Next()
+		return
+	}
+	userID, err := ctx.Cookie(userIDCookie)
+	if _, parseErr := uuid.Parse(userID); err != nil || parseErr != nil {
+		userID = uuid.NewString()
+	}
+	setCookie(ctx, userIDCookie, userID, cookieMaxAge)
+	ctx.Set(identityKey, Identity{UserID: userID})
+	ctx.Next()
+}
+
+// Returns the Identity the identify middleware resolved for a request,
+// preferring an explicit user ID over the cookie unless the user opted out.
+func identity(ctx *gin.Context, explicitUserID string) Identity {
+	id, _ := ctx.MustGet(identityKey).(Identity)
+	if !id.OptedOut && explicitUserID != "" {
+		id.UserID = explicitUserID
+	}
+	return id
+}
+
+// Opts the browser out of user-level tracking and forgets its user ID.
+func (r *router) PostOptOut(ctx *gin.Context) {
+	setCookie(ctx, optOutCookie, "1", cookieMaxAge)
+	setCookie(ctx, userIDCookie, "", -1)
+	ctx.Status(http.StatusNoContent)
+}
+
+// Opts the browser back in. It is issued a new user ID on its next request.
+func (r *router) DeleteOptOut(ctx *gin.Context) {
+	setCookie(ctx, optOutCookie, "", -1)
+	ctx.Status(http.StatusNoContent)
+}
+
+// Sets a first-party cookie for the whole site. A negative max age deletes it.
+func setCookie(ctx *gin.Context, name, value string, maxAge time.Duration) {
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	seconds := int(maxAge / time.Second)
+	if maxAge < 0 {
+		seconds = -1
+	}
+	ctx.SetCookie(name, value, seconds, "/", "", ctx.Request.TLS != nil, true)
+}
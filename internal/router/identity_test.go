@@ -0,0 +1,145 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kriscampos/adserver/internal/ad_engine"
+	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/frequency"
+	"github.com/kriscampos/adserver/internal/impression"
+	"github.com/kriscampos/adserver/internal/signing"
+	"github.com/kriscampos/adserver/internal/traffic"
+)
+
+// Requests an ad decision for "cat" with the given cookies, returning the
+// recommended campaign and the response's cookies.
+func decideWithCookies(r *gin.Engine, body gin.H, cookies ...*http.Cookie) (int, map[string]*http.Cookie) {
+	encoded, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/addecision", strings.NewReader(string(encoded)))
+	req.Header.Set("Content-Type", "application/json")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var response struct {
+		CampaignID *int `json:"campaign_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	set := make(map[string]*http.Cookie)
+	for _, c := range w.Result().Cookies() {
+		set[c.Name] = c
+	}
+	if response.CampaignID == nil {
+		return -1, set
+	}
+	return *response.CampaignID, set
+}
+
+func TestIdentify(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	now := time.Now()
+	w := serve(r, http.MethodPost, "/campaign", campaign.PostCampaignRequest{
+		StartTimestamp: now.Add(-time.Hour).Unix(),
+		EndTimestamp:   now.Add(time.Hour).Unix(),
+		TargetKeywords: []string{"cat"},
+		MaxImpression:  100,
+		CPM:            1.0,
+		FrequencyCaps:  []frequency.Cap{{Impressions: 1, WindowSeconds: 60 * 60}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to create campaign. Status: %d Body: %s", w.Code, w.Body)
+	}
+	body := gin.H{"keywords": []string{"cat"}}
+
+	id, cookies := decideWithCookies(r, body)
+	userID, ok := cookies[userIDCookie]
+	if id != 0 || !ok || userID.Value == "" {
+		t.Fatalf("Expected campaign 0 and a user ID cookie but Found campaign %d and cookies %v", id, cookies)
+	}
+	if id, cookies := decideWithCookies(r, body, userID); id != -1 || cookies[userIDCookie].Value != userID.Value {
+		t.Errorf("Expected the returning user to keep their ID and be capped but Found campaign %d and cookies %v", id, cookies)
+	}
+	if id, _ := decideWithCookies(r, gin.H{"keywords": []string{"cat"}, "user_id": "alice"}, userID); id != 0 {
+		t.Errorf("Expected an explicit user ID to be used over the cookie but Found campaign %d", id)
+	}
+	if id, _ := decideWithCookies(r, gin.H{"keywords": []string{"cat"}, "user_id": "alice"}); id != -1 {
+		t.Errorf("Expected the explicit user to be capped but Found campaign %d", id)
+	}
+
+	optOut := serve(r, http.MethodPost, "/optout", nil).Result().Cookies()
+	optOutCookies := make(map[string]*http.Cookie)
+	for _, c := range optOut {
+		optOutCookies[c.Name] = c
+	}
+	if c, ok := optOutCookies[userIDCookie]; !ok || c.MaxAge >= 0 {
+		t.Errorf("Expected opting out to delete the user ID cookie but Found %v", c)
+	}
+	for i := 0; i < 2; i++ {
+		id, cookies := decideWithCookies(r, gin.H{"keywords": []string{"cat"}, "user_id": "alice"}, optOutCookies[optOutCookie], userID)
+		if id != 0 {
+			t.Errorf("Expected an opted out user not to be capped but Found campaign %d", id)
+		}
+		if _, ok := cookies[userIDCookie]; ok {
+			t.Error("Expected an opted out user not to be issued a user ID.")
+		}
+	}
+}
+
+func TestPostAdDecision_ClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	campaignService := campaign.NewCampaignService(campaign.NewMemoryStore())
+	key, _ := signing.GenerateKey("test")
+	signer, _ := signing.NewSigner([]signing.Key{key})
+	tracker := impression.NewTracker()
+	r, err := SetupRouter(ad_engine.NewAdEngine(), campaignService, tracker, signer, traffic.NewProfile(), frequency.NewStore())
+	if err != nil {
+		t.Fatalf("Failed to set up router: %v", err)
+	}
+	now := time.Now()
+	serve(r, http.MethodPost, "/campaign", campaign.PostCampaignRequest{
+		StartTimestamp: now.Add(-time.Hour).Unix(),
+		EndTimestamp:   now.Add(time.Hour).Unix(),
+		TargetKeywords: []string{"cat"},
+		MaxImpression:  100,
+		CPM:            1.0,
+	})
+
+	// Returns the client IP kept for a decision requested with the cookies.
+	clientIP := func(cookies ...*http.Cookie) string {
+		encoded, _ := json.Marshal(gin.H{"keywords": []string{"cat"}})
+		req := httptest.NewRequest(http.MethodPost, "/addecision", strings.NewReader(string(encoded)))
+		req.Header.Set("Content-Type", "application/json")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response struct {
+			ImpressionURL string `json:"impression_url"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		token, err := signer.Verify(impressionPurpose, response.ImpressionURL)
+		if err != nil {
+			t.Fatalf("Failed to verify impression URL %q: %v", response.ImpressionURL, err)
+		}
+		decision, err := tracker.Redeem(token)
+		if err != nil {
+			t.Fatalf("Failed to redeem token: %v", err)
+		}
+		return decision.Request.ClientIP
+	}
+
+	if ip := clientIP(); ip != "192.0.2.1" {
+		t.Errorf("Expected the client IP to be kept but Found %q", ip)
+	}
+	if ip := clientIP(&http.Cookie{Name: optOutCookie, Value: "1"}); ip != "" {
+		t.Errorf("Expected no client IP for an opted out user but Found %q", ip)
+	}
+}
@@ -1,50 +1,125 @@
 package router
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kriscampos/adserver/internal/ad_engine"
 	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/frequency"
+	"github.com/kriscampos/adserver/internal/impression"
+	"github.com/kriscampos/adserver/internal/signing"
+	"github.com/kriscampos/adserver/internal/traffic"
 )
 
+// Purpose impression tokens are signed for, so they cannot be used as other
+// tracking URLs.
+const impressionPurpose = "impression"
+
 type postAdDecisionRequest struct {
 	Keywords []string `json:"keywords" binding:"required"`
+	// Relevance weights of keywords, which weigh 1 by default.
+	KeywordWeights map[string]float64 `json:"keyword_weights" binding:"omitempty,dive,gt=0"`
+	// Identifies the viewer in place of the user ID cookie.
+	UserID string `json:"user_id"`
+	// Number of ad slots to fill. Requests without placements get a single ad
+	// in place of a list of placements.
+	Placements          int  `json:"placements" binding:"omitempty,min=1,max=10"`
+	DistinctAdvertisers bool `json:"distinct_advertisers"`
+}
+
+type putFrequencyCapsRequest struct {
+	FrequencyCaps []frequency.Cap `json:"frequency_caps"`
 }
 
 type router struct {
+	// Held while a change to a campaign is applied to both the campaign
+	// service and the ad engine, so the engine sees changes in the order the
+	// service made them.
+	writeMu         sync.Mutex
 	campaignService *campaign.CampaignService
 	adEngine        *ad_engine.AdEngine
+	tracker         *impression.Tracker
+	signer          *signing.Signer
+	traffic         *traffic.Profile
+	frequency       *frequency.Store
 }
 
-func newRouter(engine *ad_engine.AdEngine) *router {
+func newRouter(engine *ad_engine.AdEngine, campaignService *campaign.CampaignService, tracker *impression.Tracker, signer *signing.Signer, profile *traffic.Profile, frequencyStore *frequency.Store) *router {
 	return &router{
-		campaignService: campaign.NewCampaignService(),
+		campaignService: campaignService,
 		adEngine:        engine,
+		tracker:         tracker,
+		signer:          signer,
+		traffic:         profile,
+		frequency:       frequencyStore,
 	}
 }
 
-func SetupRouter(adEngine *ad_engine.AdEngine) *gin.Engine {
+// Reloads stored campaigns into the ad engine and registers all routes.
+func SetupRouter(adEngine *ad_engine.AdEngine, campaignService *campaign.CampaignService, tracker *impression.Tracker, signer *signing.Signer, profile *traffic.Profile, frequencyStore *frequency.Store) (*gin.Engine, error) {
+	handler := newRouter(adEngine, campaignService, tracker, signer, profile, frequencyStore)
+	if err := handler.reloadCampaigns(); err != nil {
+		return nil, err
+	}
+
+	useJSONFieldNames()
 	router := gin.Default()
 
 	// Middleware goes here
 
-	handler := newRouter(adEngine)
 	router.POST("/campaign", handler.PostCampaign)
-	router.POST("/addecision", handler.PostAdDecision)
-	router.GET("/:impression-url", handler.GetImpressionURL)
+	router.GET("/campaign/:id", handler.GetCampaign)
+	router.PATCH("/campaign/:id", handler.PatchCampaign)
+	router.DELETE("/campaign/:id", handler.DeleteCampaign)
+	router.POST("/campaign/:id/publish", handler.transitionCampaign(campaign.EventPublish))
+	router.POST("/campaign/:id/pause", handler.transitionCampaign(campaign.EventPause))
+	router.POST("/campaign/:id/resume", handler.transitionCampaign(campaign.EventResume))
+	router.POST("/campaign/:id/archive", handler.transitionCampaign(campaign.EventArchive))
+	router.GET("/campaigns", handler.GetCampaigns)
+	router.POST("/addecision", identify, handler.PostAdDecision)
+	router.POST("/optout", handler.PostOptOut)
+	router.DELETE("/optout", handler.DeleteOptOut)
+	router.GET("/advertiser/:advertiser/frequency_caps", handler.GetFrequencyCaps)
+	router.PUT("/advertiser/:advertiser/frequency_caps", handler.PutFrequencyCaps)
+	router.GET("/admin/traffic", handler.GetTraffic)
+	router.GET("/admin/allocation", handler.GetAllocation)
+	router.GET("/:token", identify, handler.GetImpression)
 
-	return router
+	return router, nil
+}
+
+// Registers every stored campaign with the ad engine, which serves the live ones.
+func (r *router) reloadCampaigns() error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	campaigns, err := r.campaignService.LoadCampaigns()
+	if err != nil {
+		return err
+	}
+	for _, c := range campaigns {
+		r.adEngine.RegisterCampaign(c)
+	}
+	log.Printf("Reloaded %d campaigns\n", len(campaigns))
+	return nil
 }
 
 func (r *router) PostCampaign(ctx *gin.Context) {
 	var postCampaignRequest campaign.PostCampaignRequest
-	if err := ctx.BindJSON(&postCampaignRequest); err != nil {
-		ctx.Error(err)
-		ctx.AbortWithStatus(http.StatusBadRequest)
+	if !bindJSON(ctx, &postCampaignRequest) {
+		return
+	}
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	newCampaign, err := r.campaignService.CreateCampaign(&postCampaignRequest)
+	if err != nil {
+		abortWithCampaignError(ctx, err)
+		return
 	}
-	newCampaign := r.campaignService.CreateCampaign(&postCampaignRequest)
 	r.adEngine.RegisterCampaign(newCampaign)
 	responseData := gin.H{
 		"campaign_id": newCampaign.ID,
@@ -52,32 +127,280 @@ func (r *router) PostCampaign(ctx *gin.Context) {
 	ctx.IndentedJSON(http.StatusOK, responseData)
 }
 
+func (r *router) GetCampaign(ctx *gin.Context) {
+	id, ok := campaignID(ctx)
+	if !ok {
+		return
+	}
+	c, err := r.campaignService.GetCampaign(id)
+	if err != nil {
+		abortWithCampaignError(ctx, err)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, c)
+}
+
+func (r *router) GetCampaigns(ctx *gin.Context) {
+	var filter campaign.CampaignFilter
+	if !bindQuery(ctx, &filter) {
+		return
+	}
+	campaigns, total, err := r.campaignService.ListCampaigns(&filter)
+	if err != nil {
+		abortWithCampaignError(ctx, err)
+		return
+	}
+	responseData := gin.H{
+		"campaigns": campaigns,
+		"total":     total,
+	}
+	ctx.IndentedJSON(http.StatusOK, responseData)
+}
+
+func (r *router) PatchCampaign(ctx *gin.Context) {
+	id, ok := campaignID(ctx)
+	if !ok {
+		return
+	}
+	var patchCampaignRequest campaign.PatchCampaignRequest
+	if !bindJSON(ctx, &patchCampaignRequest) {
+		return
+	}
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	updated, err := r.campaignService.UpdateCampaign(id, &patchCampaignRequest)
+	if err != nil {
+		abortWithCampaignError(ctx, err)
+		return
+	}
+	r.adEngine.UpdateCampaign(updated)
+	ctx.IndentedJSON(http.StatusOK, updated)
+}
+
+// Returns a handler that applies a lifecycle event to a campaign.
+func (r *router) transitionCampaign(event campaign.Event) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := campaignID(ctx)
+		if !ok {
+			return
+		}
+		r.writeMu.Lock()
+		defer r.writeMu.Unlock()
+		updated, err := r.campaignService.TransitionCampaign(id, event)
+		if err != nil {
+			abortWithCampaignError(ctx, err)
+			return
+		}
+		r.adEngine.UpdateCampaign(updated)
+		ctx.IndentedJSON(http.StatusOK, updated)
+	}
+}
+
+func (r *router) DeleteCampaign(ctx *gin.Context) {
+	id, ok := campaignID(ctx)
+	if !ok {
+		return
+	}
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if _, err := r.campaignService.DeleteCampaign(id); err != nil {
+		abortWithCampaignError(ctx, err)
+		return
+	}
+	r.adEngine.DeleteCampaign(id)
+	ctx.Status(http.StatusNoContent)
+}
+
 func (r *router) PostAdDecision(ctx *gin.Context) {
 	var newAdDecisionRequest postAdDecisionRequest
-	if err := ctx.BindJSON(&newAdDecisionRequest); err != nil {
-		ctx.Error(err)
-		ctx.AbortWithStatus(http.StatusBadRequest)
+	if !bindJSON(ctx, &newAdDecisionRequest) {
+		return
 	}
-	campaign, ok := r.adEngine.RecommendCampaign(newAdDecisionRequest.Keywords)
-	if !ok {
-		return // returns status 200
+	r.traffic.Record(newAdDecisionRequest.Keywords)
+	id := identity(ctx, newAdDecisionRequest.UserID)
+	userID := id.UserID
+	// Only campaigns the user has not seen too often that can still pay for an
+	// impression at their CPM and are not ahead of their pacing take part in
+	// the auction. Each winner's impression is reserved at its CPM and then
+	// repriced to its clearing price. Reservations are released if their
+	// tokens expire unused.
+	auction := ad_engine.AuctionRequest{
+		Keywords:            newAdDecisionRequest.Keywords,
+		KeywordWeights:      newAdDecisionRequest.KeywordWeights,
+		Slots:               newAdDecisionRequest.Placements,
+		DistinctAdvertisers: newAdDecisionRequest.DistinctAdvertisers,
+	}
+	result, ok := r.adEngine.RunAuction(auction, func(candidate ad_engine.Candidate) bool {
+		c := candidate.Campaign
+		exposure, ok := r.frequency.Reserve(userID, c.ID, c.FrequencyCaps, c.Advertiser)
+		if !ok {
+			return false
+		}
+		if !r.reserveImpression(c, campaign.ImpressionPrice(candidate.Bid)) {
+			r.frequency.Forget(exposure)
+			return false
+		}
+		return true
+	}, func(candidate ad_engine.Candidate) bool {
+		c := candidate.Campaign
+		return r.frequency.Allowed(userID, c.ID, c.FrequencyCaps, c.Advertiser) &&
+			r.campaignService.CanReserveImpression(c.ID, campaign.ImpressionPrice(candidate.Bid)) == nil
+	})
+	if newAdDecisionRequest.Placements == 0 {
+		if !ok {
+			ctx.Status(http.StatusNoContent)
+			return
+		}
+		ctx.IndentedJSON(http.StatusOK, r.issuePlacement(ctx, result.Placements[0], newAdDecisionRequest.Keywords, id))
+		return
+	}
+	placements := []gin.H{}
+	if ok {
+		for _, p := range result.Placements {
+			placements = append(placements, r.issuePlacement(ctx, p, newAdDecisionRequest.Keywords, id))
+		}
+	}
+	ctx.IndentedJSON(http.StatusOK, gin.H{"placements": placements})
+}
+
+// Settles the reservation of a placed campaign at its clearing price and issues
+// the impression token for it. The client's IP is not kept for opted out users.
+func (r *router) issuePlacement(ctx *gin.Context, p ad_engine.Placement, keywords []string, id Identity) gin.H {
+	price := campaign.ImpressionPrice(p.ClearingCPM)
+	if err := r.campaignService.RepriceImpression(p.Campaign.ID, campaign.ImpressionPrice(p.Bid), price); err != nil {
+		log.Printf("Failed to reprice impression for campaign %d: %v\n", p.Campaign.ID, err)
+	}
+	request := impression.Request{Keywords: keywords, UserID: id.UserID}
+	if !id.OptedOut {
+		request.ClientIP = ctx.ClientIP()
+	}
+	decision := r.tracker.Issue(p.Campaign.ID, price, request)
+	return gin.H{
+		"campaign_id":    p.Campaign.ID,
+		"clearing_cpm":   p.ClearingCPM,
+		"impression_url": r.signer.Sign(impressionPurpose, decision.Token),
 	}
+}
+
+func (r *router) reserveImpression(c *campaign.Campaign, price float64) bool {
+	_, err := r.campaignService.ReserveImpression(c.ID, price)
+	if err != nil && !errors.Is(err, campaign.ErrNoCapacity) &&
+		!errors.Is(err, campaign.ErrNotServable) && !errors.Is(err, campaign.ErrThrottled) {
+		log.Printf("Failed to reserve impression for campaign %d: %v\n", c.ID, err)
+	}
+	return err == nil
+}
+
+func (r *router) GetFrequencyCaps(ctx *gin.Context) {
 	responseData := gin.H{
-		"campaign_id":    campaign.ID,
-		"impression_url": campaign.ImpressionURL,
+		"frequency_caps": r.frequency.AdvertiserCaps(ctx.Param("advertiser")),
 	}
 	ctx.IndentedJSON(http.StatusOK, responseData)
 }
 
-func (r *router) GetImpressionURL(ctx *gin.Context) {
-	impressionURL := ctx.Param("impression-url")
-	log.Printf("Impression URL: %s\n", impressionURL)
-	reachedMax, validURL := r.campaignService.IncrementImpression(impressionURL)
-	if !validURL {
-		ctx.AbortWithStatus(http.StatusBadRequest)
+// Replaces the frequency caps shared by all of an advertiser's campaigns.
+func (r *router) PutFrequencyCaps(ctx *gin.Context) {
+	var request putFrequencyCapsRequest
+	if !bindJSON(ctx, &request) {
+		return
+	}
+	if err := r.frequency.SetAdvertiserCaps(ctx.Param("advertiser"), request.FrequencyCaps); err != nil {
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, request)
+}
+
+// Returns the learned traffic profile for the keyword query parameter, or the
+// overall profile without one.
+func (r *router) GetTraffic(ctx *gin.Context) {
+	ctx.IndentedJSON(http.StatusOK, r.traffic.Snapshot(ctx.Query("keyword")))
+}
+
+// Returns how traffic is currently allocated to guaranteed campaigns, or 404
+// until a plan has been made.
+func (r *router) GetAllocation(ctx *gin.Context) {
+	plan := r.adEngine.AllocationPlan()
+	if plan == nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, plan)
+}
+
+// Records the impression for a decision. Each decision's token is only accepted
+// once, and only when it carries a valid signature.
+func (r *router) GetImpression(ctx *gin.Context) {
+	token, err := r.signer.Verify(impressionPurpose, ctx.Param("token"))
+	if err != nil {
+		abortWithImpressionError(ctx, err)
+		return
+	}
+	decision, err := r.tracker.Redeem(token)
+	if err != nil {
+		abortWithImpressionError(ctx, err)
+		return
+	}
+	log.Printf("Impression for campaign %d decided at %s\n", decision.CampaignID, decision.IssuedAt)
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	c, capped, err := r.campaignService.CommitImpression(decision.CampaignID, decision.Price)
+	if err != nil {
+		abortWithCampaignError(ctx, err)
+		return
+	}
+	if capped {
+		// Drops an exhausted campaign, or holds back a campaign that reached a
+		// daily cap until the cap resets.
+		log.Printf("Campaign %d reached a cap\n", c.ID)
+		r.adEngine.UpdateCampaign(c)
+	}
+}
+
+// Parses the campaign ID path parameter, aborting the request with a
+// structured error when it is malformed.
+func campaignID(ctx *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(err)
+		abortWithValidationError(ctx, &campaign.ValidationError{Fields: []campaign.FieldError{{
+			Field:   "id",
+			Code:    campaign.CodeInvalidType,
+			Message: "must be an int",
+		}}})
+		return 0, false
 	}
-	if reachedMax {
-		log.Printf("Reached Max!\n")
-		r.adEngine.DeleteCampaign(impressionURL)
+	return id, true
+}
+
+func abortWithCampaignError(ctx *gin.Context, err error) {
+	ctx.Error(err)
+	var validationErr *campaign.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		abortWithValidationError(ctx, validationErr)
+	case errors.Is(err, campaign.ErrCampaignNotFound):
+		ctx.AbortWithStatus(http.StatusNotFound)
+	case errors.Is(err, campaign.ErrInvalidTransition),
+		errors.Is(err, campaign.ErrNoCapacity),
+		errors.Is(err, campaign.ErrNoReservation):
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+func abortWithImpressionError(ctx *gin.Context, err error) {
+	ctx.Error(err)
+	switch {
+	case errors.Is(err, signing.ErrInvalidSignature):
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, impression.ErrTokenUsed):
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, impression.ErrTokenExpired):
+		ctx.AbortWithStatusJSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 	}
 }
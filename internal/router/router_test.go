@@ -0,0 +1,898 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kriscampos/adserver/internal/ad_engine"
+	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/frequency"
+	"github.com/kriscampos/adserver/internal/impression"
+	"github.com/kriscampos/adserver/internal/signing"
+	"github.com/kriscampos/adserver/internal/traffic"
+)
+
+func setupTestRouter(t *testing.T) (*gin.Engine, *campaign.CampaignService) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	campaignService := campaign.NewCampaignService(campaign.NewMemoryStore())
+	key, _ := signing.GenerateKey("test")
+	signer, _ := signing.NewSigner([]signing.Key{key})
+	tracker := impression.NewTracker(impression.WithExpiryHandler(func(d *impression.Decision) {
+		campaignService.ReleaseImpression(d.CampaignID, d.Price)
+	}))
+	r, err := SetupRouter(ad_engine.NewAdEngine(), campaignService, tracker, signer, traffic.NewProfile(), frequency.NewStore())
+	if err != nil {
+		t.Fatalf("Failed to set up router: %v", err)
+	}
+	return r, campaignService
+}
+
+func serve(r *gin.Engine, method, path string, body any) *httptest.ResponseRecorder {
+	var reader bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&reader).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func postCampaign(t *testing.T, r *gin.Engine, keyword string, maxImpression int) int {
+	t.Helper()
+	id, err := createCampaign(r, keyword, maxImpression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// Creates a live campaign for a keyword, returning its ID. Unlike
+// postCampaign it can be called from goroutines other than the test's.
+func createCampaign(r *gin.Engine, keyword string, maxImpression int) (int, error) {
+	now := time.Now()
+	w := serve(r, http.MethodPost, "/campaign", campaign.PostCampaignRequest{
+		StartTimestamp: now.Add(-time.Hour).Unix(),
+		EndTimestamp:   now.Add(time.Hour).Unix(),
+		TargetKeywords: []string{keyword},
+		MaxImpression:  maxImpression,
+		CPM:            1.0,
+	})
+	if w.Code != http.StatusOK {
+		return 0, fmt.Errorf("failed to create campaign. Status: %d Body: %s", w.Code, w.Body)
+	}
+	var response struct {
+		CampaignID int `json:"campaign_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response.CampaignID, nil
+}
+
+// Serves decisions and impressions while campaigns are being created, checking
+// that every impression is counted exactly once and never past the max.
+func TestRouter_ConcurrentTraffic(t *testing.T) {
+	r, campaignService := setupTestRouter(t)
+	const maxImpression = 50
+	id := postCampaign(t, r, "cat", maxImpression)
+
+	const workers = 8
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		counted int
+		// Workers report failures here, since only the test's goroutine may
+		// stop the test.
+		errs = make(chan error, workers*10)
+	)
+	for w := 0; w < workers; w++ {
+		w := w
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				decision := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}})
+				if decision.Code == http.StatusNoContent {
+					continue // The campaign ran out of impressions.
+				}
+				var response struct {
+					ImpressionURL string `json:"impression_url"`
+				}
+				json.Unmarshal(decision.Body.Bytes(), &response)
+				if serve(r, http.MethodGet, "/"+response.ImpressionURL, nil).Code == http.StatusOK {
+					mu.Lock()
+					counted++
+					mu.Unlock()
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if _, err := createCampaign(r, fmt.Sprintf("dog%d", w), 10); err != nil {
+					errs <- err
+				}
+				serve(r, http.MethodGet, "/campaigns", nil)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	c, err := campaignService.GetCampaign(id)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.ImpressionCount != counted {
+		t.Errorf("Expected %d impressions but Found %d", counted, c.ImpressionCount)
+	}
+	if counted != maxImpression {
+		t.Errorf("Expected exactly %d impressions but Found %d", maxImpression, counted)
+	}
+}
+
+func TestGetImpression_SingleUse(t *testing.T) {
+	r, campaignService := setupTestRouter(t)
+	id := postCampaign(t, r, "cat", 10)
+
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		var response struct {
+			ImpressionURL string `json:"impression_url"`
+		}
+		decision := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}})
+		json.Unmarshal(decision.Body.Bytes(), &response)
+		tokens = append(tokens, response.ImpressionURL)
+	}
+	if tokens[0] == tokens[1] {
+		t.Fatalf("Expected every decision to get its own token but Found %s twice", tokens[0])
+	}
+
+	expecteds := []int{http.StatusOK, http.StatusConflict, http.StatusOK, http.StatusForbidden}
+	paths := []string{"/" + tokens[0], "/" + tokens[0], "/" + tokens[1], "/made-up"}
+	for i, path := range paths {
+		if code := serve(r, http.MethodGet, path, nil).Code; code != expecteds[i] {
+			t.Errorf("GET %s: Expected status %d but Found %d", path, expecteds[i], code)
+		}
+	}
+	if c, _ := campaignService.GetCampaign(id); c.ImpressionCount != 2 {
+		t.Errorf("Expected 2 impressions but Found %d", c.ImpressionCount)
+	}
+}
+
+func TestGetImpression_Forged(t *testing.T) {
+	r, campaignService := setupTestRouter(t)
+	id := postCampaign(t, r, "cat", 10)
+	var response struct {
+		ImpressionURL string `json:"impression_url"`
+	}
+	decision := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}})
+	json.Unmarshal(decision.Body.Bytes(), &response)
+	token, _, _ := strings.Cut(response.ImpressionURL, ".")
+
+	forgedKey, _ := signing.GenerateKey("test")
+	forger, _ := signing.NewSigner([]signing.Key{forgedKey})
+	for _, forged := range []string{token, forger.Sign(impressionPurpose, token)} {
+		if code := serve(r, http.MethodGet, "/"+forged, nil).Code; code != http.StatusForbidden {
+			t.Errorf("GET /%s: Expected status %d but Found %d", forged, http.StatusForbidden, code)
+		}
+	}
+	if c, _ := campaignService.GetCampaign(id); c.ImpressionCount != 0 {
+		t.Errorf("Expected forged impressions to be ignored but Found %d", c.ImpressionCount)
+	}
+	// The real token is still good after the forgeries.
+	if code := serve(r, http.MethodGet, "/"+response.ImpressionURL, nil).Code; code != http.StatusOK {
+		t.Errorf("Expected status %d but Found %d", http.StatusOK, code)
+	}
+}
+
+func TestPostAdDecision_FallsThroughThrottledCampaign(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	now := time.Now()
+	var ids []int
+	// The even-paced campaign is 1% into its flight, so it may only run one
+	// impression ahead of its 0.1 expected impressions.
+	for _, request := range []campaign.PostCampaignRequest{
+		{CPM: 5.0, Pacing: campaign.PacingEven, EndTimestamp: now.Add(99 * time.Hour).Unix()},
+		{CPM: 1.0, Pacing: campaign.PacingASAP, EndTimestamp: now.Add(99 * time.Hour).Unix()},
+	} {
+		request.StartTimestamp = now.Add(-time.Hour).Unix()
+		request.TargetKeywords = []string{"cat"}
+		request.MaxImpression = 10
+		w := serve(r, http.MethodPost, "/campaign", request)
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		ids = append(ids, response.CampaignID)
+	}
+
+	for _, expectedID := range ids {
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		decision := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}})
+		json.Unmarshal(decision.Body.Bytes(), &response)
+		if response.CampaignID != expectedID {
+			t.Errorf("Expected campaign %d but Found %d", expectedID, response.CampaignID)
+		}
+	}
+}
+
+func TestGetTraffic(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"Cat", "dog"}})
+	serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}})
+
+	expecteds := map[string]uint64{"": 2, "cat": 2, "dog": 1, "bird": 0}
+	for keyword, expected := range expecteds {
+		var snapshot traffic.Snapshot
+		w := serve(r, http.MethodGet, "/admin/traffic?keyword="+keyword, nil)
+		json.Unmarshal(w.Body.Bytes(), &snapshot)
+		if snapshot.Decisions != expected {
+			t.Errorf("Expected %d decisions for %q but Found %d", expected, keyword, snapshot.Decisions)
+		}
+	}
+}
+
+func TestGetAllocation_NoPlan(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	if w := serve(r, http.MethodGet, "/admin/allocation", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d but Found %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestPostAdDecision_FrequencyCaps(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	now := time.Now()
+	daily := func(impressions int) []frequency.Cap {
+		return []frequency.Cap{{Impressions: impressions, WindowSeconds: 24 * 60 * 60}}
+	}
+	var ids []int
+	for _, request := range []campaign.PostCampaignRequest{
+		{CPM: 5.0, Advertiser: "acme", FrequencyCaps: daily(2)},
+		{CPM: 3.0, Advertiser: "acme"},
+		{CPM: 1.0, Advertiser: "other"},
+	} {
+		request.StartTimestamp = now.Add(-time.Hour).Unix()
+		request.EndTimestamp = now.Add(time.Hour).Unix()
+		request.TargetKeywords = []string{"cat"}
+		request.MaxImpression = 100
+		w := serve(r, http.MethodPost, "/campaign", request)
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		ids = append(ids, response.CampaignID)
+	}
+	w := serve(r, http.MethodPut, "/advertiser/acme/frequency_caps", gin.H{"frequency_caps": daily(3)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to set advertiser caps. Status: %d Body: %s", w.Code, w.Body)
+	}
+
+	decide := func(userID string) int {
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		w := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}, "user_id": userID})
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.CampaignID
+	}
+	var actual []int
+	for i := 0; i < 5; i++ {
+		actual = append(actual, decide("alice"))
+	}
+	expected := []int{ids[0], ids[0], ids[1], ids[2], ids[2]}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("Unexpected campaigns for a capped user (-want +got):\n%s", diff)
+	}
+	if id := decide("bob"); id != ids[0] {
+		t.Errorf("Expected another user to be shown campaign %d but Found %d", ids[0], id)
+	}
+	if id := decide(""); id != ids[0] {
+		t.Errorf("Expected an anonymous request to be shown campaign %d but Found %d", ids[0], id)
+	}
+}
+
+func TestPutFrequencyCaps_Invalid(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	w := serve(r, http.MethodPut, "/advertiser/acme/frequency_caps", gin.H{
+		"frequency_caps": []frequency.Cap{{Impressions: 3}},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d but Found %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestPostAdDecision_SecondPrice(t *testing.T) {
+	r, campaignService := setupTestRouter(t)
+	now := time.Now()
+	var ids []int
+	for _, cpm := range []float64{5.0, 3.0} {
+		w := serve(r, http.MethodPost, "/campaign", campaign.PostCampaignRequest{
+			StartTimestamp: now.Add(-time.Hour).Unix(),
+			EndTimestamp:   now.Add(time.Hour).Unix(),
+			TargetKeywords: []string{"cat"},
+			MaxImpression:  10,
+			CPM:            cpm,
+		})
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		ids = append(ids, response.CampaignID)
+	}
+
+	var decision struct {
+		CampaignID    int     `json:"campaign_id"`
+		ClearingCPM   float64 `json:"clearing_cpm"`
+		ImpressionURL string  `json:"impression_url"`
+	}
+	w := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}})
+	json.Unmarshal(w.Body.Bytes(), &decision)
+	if decision.CampaignID != ids[0] || decision.ClearingCPM != 3.01 {
+		t.Fatalf("Expected campaign %d to win at 3.01 but Found: %s", ids[0], w.Body)
+	}
+	if w := serve(r, http.MethodGet, "/"+decision.ImpressionURL, nil); w.Code != http.StatusOK {
+		t.Fatalf("Failed to record impression. Status: %d Body: %s", w.Code, w.Body)
+	}
+	c, _ := campaignService.GetCampaign(ids[0])
+	if expected := campaign.ImpressionPrice(3.01); c.Spend != expected {
+		t.Errorf("Expected the impression to spend %f but Found %f", expected, c.Spend)
+	}
+}
+
+func TestPostAdDecision_Placements(t *testing.T) {
+	r, campaignService := setupTestRouter(t)
+	now := time.Now()
+	var ids []int
+	for _, request := range []campaign.PostCampaignRequest{
+		{CPM: 5.0, Advertiser: "acme"},
+		{CPM: 4.0, Advertiser: "acme"},
+		{CPM: 3.0, Advertiser: "globex"},
+	} {
+		request.StartTimestamp = now.Add(-time.Hour).Unix()
+		request.EndTimestamp = now.Add(time.Hour).Unix()
+		request.TargetKeywords = []string{"cat"}
+		request.MaxImpression = 10
+		w := serve(r, http.MethodPost, "/campaign", request)
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		ids = append(ids, response.CampaignID)
+	}
+
+	type placement struct {
+		CampaignID    int     `json:"campaign_id"`
+		ClearingCPM   float64 `json:"clearing_cpm"`
+		ImpressionURL string  `json:"impression_url"`
+	}
+	decide := func(body gin.H) []placement {
+		var response struct {
+			Placements []placement `json:"placements"`
+		}
+		w := serve(r, http.MethodPost, "/addecision", body)
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Placements
+	}
+	ignoreURL := cmpopts.IgnoreFields(placement{}, "ImpressionURL")
+
+	expected := []placement{{CampaignID: ids[0], ClearingCPM: 4.01}, {CampaignID: ids[1], ClearingCPM: 3.01}}
+	actual := decide(gin.H{"keywords": []string{"cat"}, "placements": 2})
+	if diff := cmp.Diff(expected, actual, ignoreURL); diff != "" {
+		t.Fatalf("Placements mismatch (-want +got):\n%s", diff)
+	}
+	if w := serve(r, http.MethodGet, "/"+actual[1].ImpressionURL, nil); w.Code != http.StatusOK {
+		t.Fatalf("Failed to record impression. Status: %d Body: %s", w.Code, w.Body)
+	}
+	if c, _ := campaignService.GetCampaign(ids[1]); c.Spend != campaign.ImpressionPrice(3.01) {
+		t.Errorf("Expected the second position to spend %f but Found %f", campaign.ImpressionPrice(3.01), c.Spend)
+	}
+
+	expected = []placement{{CampaignID: ids[0], ClearingCPM: 3.01}, {CampaignID: ids[2], ClearingCPM: ad_engine.DefaultFloorCPM}}
+	actual = decide(gin.H{"keywords": []string{"cat"}, "placements": 3, "distinct_advertisers": true})
+	if diff := cmp.Diff(expected, actual, ignoreURL); diff != "" {
+		t.Errorf("Placements with distinct advertisers mismatch (-want +got):\n%s", diff)
+	}
+
+	if actual := decide(gin.H{"keywords": []string{"bird"}, "placements": 2}); actual == nil || len(actual) != 0 {
+		t.Errorf("Expected an empty list of placements but Found: %+v", actual)
+	}
+	w := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}, "placements": 11})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for too many placements but Found %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestPostAdDecision_KeywordBids(t *testing.T) {
+	r, campaignService := setupTestRouter(t)
+	now := time.Now()
+	var ids []int
+	for _, request := range []campaign.PostCampaignRequest{
+		{CPM: 1.0, KeywordBids: map[string]float64{"cat": 6.0}},
+		{CPM: 3.0},
+	} {
+		request.StartTimestamp = now.Add(-time.Hour).Unix()
+		request.EndTimestamp = now.Add(time.Hour).Unix()
+		request.TargetKeywords = []string{"cat", "dog"}
+		request.MaxImpression = 10
+		w := serve(r, http.MethodPost, "/campaign", request)
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		ids = append(ids, response.CampaignID)
+	}
+
+	testcases := []struct {
+		keyword     string
+		expectedID  int
+		expectedCPM float64
+	}{
+		{keyword: "cat", expectedID: ids[0], expectedCPM: 3.01},
+		{keyword: "dog", expectedID: ids[1], expectedCPM: 1.01},
+	}
+	for _, tc := range testcases {
+		var decision struct {
+			CampaignID    int     `json:"campaign_id"`
+			ClearingCPM   float64 `json:"clearing_cpm"`
+			ImpressionURL string  `json:"impression_url"`
+		}
+		w := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{tc.keyword}})
+		json.Unmarshal(w.Body.Bytes(), &decision)
+		if decision.CampaignID != tc.expectedID || decision.ClearingCPM != tc.expectedCPM {
+			t.Errorf("Expected campaign %d to win %q at %v but Found: %s", tc.expectedID, tc.keyword, tc.expectedCPM, w.Body)
+			continue
+		}
+		serve(r, http.MethodGet, "/"+decision.ImpressionURL, nil)
+		c, _ := campaignService.GetCampaign(tc.expectedID)
+		if expected := campaign.ImpressionPrice(tc.expectedCPM); c.Spend != expected {
+			t.Errorf("Expected campaign %d to spend %f but Found %f", tc.expectedID, expected, c.Spend)
+		}
+	}
+}
+
+func TestPostAdDecision_KeywordWeights(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	now := time.Now()
+	var ids []int
+	for _, request := range []campaign.PostCampaignRequest{
+		{CPM: 5.0, TargetKeywords: []string{"cat"}},
+		{CPM: 3.0, TargetKeywords: []string{"cat", "dog"}},
+	} {
+		request.StartTimestamp = now.Add(-time.Hour).Unix()
+		request.EndTimestamp = now.Add(time.Hour).Unix()
+		request.MaxImpression = 10
+		w := serve(r, http.MethodPost, "/campaign", request)
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		ids = append(ids, response.CampaignID)
+	}
+
+	testcases := []struct {
+		name        string
+		weights     map[string]float64
+		expectedID  int
+		expectedCPM float64
+	}{
+		{name: "Campaign matching both keywords", expectedID: ids[1], expectedCPM: 2.51},
+		{name: "Weighted keyword", weights: map[string]float64{"cat": 3}, expectedID: ids[0], expectedCPM: 4.01},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var decision struct {
+				CampaignID  int     `json:"campaign_id"`
+				ClearingCPM float64 `json:"clearing_cpm"`
+			}
+			w := serve(r, http.MethodPost, "/addecision", gin.H{
+				"keywords":        []string{"cat", "dog"},
+				"keyword_weights": tc.weights,
+			})
+			json.Unmarshal(w.Body.Bytes(), &decision)
+			if decision.CampaignID != tc.expectedID || math.Abs(decision.ClearingCPM-tc.expectedCPM) > 1e-9 {
+				t.Errorf("Expected campaign %d to win at %v but Found: %s", tc.expectedID, tc.expectedCPM, w.Body)
+			}
+		})
+	}
+
+	w := serve(r, http.MethodPost, "/addecision", gin.H{
+		"keywords":        []string{"cat"},
+		"keyword_weights": map[string]float64{"cat": -1},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a negative weight but Found %d: %s", http.StatusBadRequest, w.Code, w.Body)
+	}
+}
+
+func TestPostAdDecision_HouseFill(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	w := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"dog"}})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d without campaigns but Found %d: %s", http.StatusNoContent, w.Code, w.Body)
+	}
+
+	now := time.Now()
+	w = serve(r, http.MethodPost, "/campaign", campaign.PostCampaignRequest{
+		StartTimestamp: now.Add(-time.Hour).Unix(),
+		EndTimestamp:   now.Add(time.Hour).Unix(),
+		TargetKeywords: []string{"promo"},
+		MaxImpression:  10,
+		CPM:            0.5,
+		Tier:           campaign.TierHouse,
+	})
+	var created struct {
+		CampaignID int `json:"campaign_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	var decision struct {
+		CampaignID  int     `json:"campaign_id"`
+		ClearingCPM float64 `json:"clearing_cpm"`
+	}
+	w = serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"dog"}})
+	json.Unmarshal(w.Body.Bytes(), &decision)
+	if w.Code != http.StatusOK || decision.CampaignID != created.CampaignID {
+		t.Errorf("Expected house campaign %d to fill the request but Found %d: %s", created.CampaignID, w.Code, w.Body)
+	}
+}
+
+// Decodes the fields of a validation_failed response.
+func validationFields(t *testing.T, w *httptest.ResponseRecorder) []campaign.FieldError {
+	t.Helper()
+	var response struct {
+		Error  string                `json:"error"`
+		Fields []campaign.FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Error != "validation_failed" {
+		t.Fatalf("Expected a validation_failed body but Found: %s", w.Body)
+	}
+	return response.Fields
+}
+
+var ignoreMessage = cmpopts.IgnoreFields(campaign.FieldError{}, "Message")
+
+func TestGetCampaign(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	id := postCampaign(t, r, "Cat", 10)
+
+	w := serve(r, http.MethodGet, fmt.Sprintf("/campaign/%d", id), nil)
+	var c campaign.Campaign
+	json.Unmarshal(w.Body.Bytes(), &c)
+	if w.Code != http.StatusOK || c.ID != id || c.Status != campaign.StatusActive {
+		t.Errorf("Expected active campaign %d but Found %d: %s", id, w.Code, w.Body)
+	}
+	if diff := cmp.Diff([]string{"cat"}, c.NormalizedKeywords); diff != "" {
+		t.Errorf("NormalizedKeywords mismatch (-want +got):\n%s", diff)
+	}
+
+	if w := serve(r, http.MethodGet, "/campaign/99", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing campaign but Found %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestPatchCampaign(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	id := postCampaign(t, r, "cat", 10)
+	path := fmt.Sprintf("/campaign/%d", id)
+
+	testcases := []struct {
+		name           string
+		path           string
+		body           gin.H
+		expectedCode   int
+		expectedFields []campaign.FieldError
+	}{
+		{
+			name:         "Valid changes",
+			path:         path,
+			body:         gin.H{"cpm": 2.5, "advertiser": "acme"},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Invalid changes",
+			path:         path,
+			body:         gin.H{"max_impression": 0, "cpm": -1},
+			expectedCode: http.StatusBadRequest,
+			expectedFields: []campaign.FieldError{
+				{Field: "max_impression", Code: campaign.CodeNotPositive},
+				{Field: "cpm", Code: campaign.CodeNotPositive},
+			},
+		},
+		{
+			name:         "Missing campaign",
+			path:         "/campaign/99",
+			body:         gin.H{"cpm": 2.5},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(r, http.MethodPatch, tc.path, tc.body)
+			if w.Code != tc.expectedCode {
+				t.Fatalf("Expected status %d but Found %d: %s", tc.expectedCode, w.Code, w.Body)
+			}
+			if tc.expectedFields != nil {
+				if diff := cmp.Diff(tc.expectedFields, validationFields(t, w), ignoreMessage); diff != "" {
+					t.Errorf("Fields mismatch (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+
+	// Only the valid changes were applied.
+	var c campaign.Campaign
+	json.Unmarshal(serve(r, http.MethodGet, path, nil).Body.Bytes(), &c)
+	if c.CPM != 2.5 || c.Advertiser != "acme" || c.MaxImpression != 10 {
+		t.Errorf("Expected the valid changes to be stored but Found %+v", c)
+	}
+}
+
+func TestDeleteCampaign(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	id := postCampaign(t, r, "cat", 10)
+	path := fmt.Sprintf("/campaign/%d", id)
+
+	if w := serve(r, http.MethodDelete, path, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d but Found %d: %s", http.StatusNoContent, w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodGet, path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d after deleting but Found %d", http.StatusNotFound, w.Code)
+	}
+	if w := serve(r, http.MethodDelete, path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d deleting twice but Found %d", http.StatusNotFound, w.Code)
+	}
+	if w := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}}); w.Code != http.StatusNoContent {
+		t.Errorf("Expected a deleted campaign not to be served but Found %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestGetCampaigns(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	now := time.Now()
+	var ids []int
+	for _, request := range []campaign.PostCampaignRequest{
+		{TargetKeywords: []string{"cat"}, Advertiser: "acme"},
+		{TargetKeywords: []string{"dog"}, Advertiser: "acme"},
+		{TargetKeywords: []string{"cat"}, Advertiser: "globex", Draft: true},
+	} {
+		request.StartTimestamp = now.Add(-time.Hour).Unix()
+		request.EndTimestamp = now.Add(time.Hour).Unix()
+		request.MaxImpression = 10
+		request.CPM = 1.0
+		w := serve(r, http.MethodPost, "/campaign", request)
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		ids = append(ids, response.CampaignID)
+	}
+
+	testcases := []struct {
+		name          string
+		query         string
+		expectedIDs   []int
+		expectedTotal int
+	}{
+		{name: "No filter", query: "", expectedIDs: ids, expectedTotal: 3},
+		{name: "Keyword is normalized", query: "?keyword=Cat", expectedIDs: []int{ids[0], ids[2]}, expectedTotal: 2},
+		{name: "Active", query: "?active=true", expectedIDs: ids[:2], expectedTotal: 2},
+		{name: "Inactive", query: "?active=false", expectedIDs: ids[2:], expectedTotal: 1},
+		{name: "Status", query: "?status=draft", expectedIDs: ids[2:], expectedTotal: 1},
+		{name: "Advertiser", query: "?advertiser=acme", expectedIDs: ids[:2], expectedTotal: 2},
+		{name: "Combined filters", query: "?keyword=cat&advertiser=acme", expectedIDs: ids[:1], expectedTotal: 1},
+		{name: "Second page", query: "?page=2&page_size=2", expectedIDs: ids[2:], expectedTotal: 3},
+		{name: "Page past the end", query: "?page=3&page_size=2", expectedIDs: []int{}, expectedTotal: 3},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, "/campaigns"+tc.query, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d but Found %d: %s", http.StatusOK, w.Code, w.Body)
+			}
+			var response struct {
+				Campaigns []campaign.Campaign `json:"campaigns"`
+				Total     int                 `json:"total"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			actualIDs := make([]int, 0, len(response.Campaigns))
+			for _, c := range response.Campaigns {
+				actualIDs = append(actualIDs, c.ID)
+			}
+			if diff := cmp.Diff(tc.expectedIDs, actualIDs); diff != "" {
+				t.Errorf("Campaigns mismatch (-want +got):\n%s", diff)
+			}
+			if response.Total != tc.expectedTotal {
+				t.Errorf("Expected a total of %d but Found %d", tc.expectedTotal, response.Total)
+			}
+		})
+	}
+}
+
+func TestTransitionCampaign(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	id := postCampaign(t, r, "cat", 10)
+	decide := func() (int, int) {
+		var response struct {
+			CampaignID int `json:"campaign_id"`
+		}
+		w := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}})
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.CampaignID
+	}
+
+	steps := []struct {
+		event          string
+		expectedCode   int
+		expectedStatus campaign.Status
+		// Whether the campaign is served after the step.
+		expectedServed bool
+	}{
+		{event: "pause", expectedCode: http.StatusOK, expectedStatus: campaign.StatusPaused},
+		{event: "pause", expectedCode: http.StatusConflict},
+		{event: "resume", expectedCode: http.StatusOK, expectedStatus: campaign.StatusActive, expectedServed: true},
+		{event: "publish", expectedCode: http.StatusConflict, expectedServed: true},
+		{event: "archive", expectedCode: http.StatusOK, expectedStatus: campaign.StatusArchived},
+		{event: "resume", expectedCode: http.StatusConflict},
+	}
+	for i, step := range steps {
+		w := serve(r, http.MethodPost, fmt.Sprintf("/campaign/%d/%s", id, step.event), nil)
+		if w.Code != step.expectedCode {
+			t.Fatalf("Step %d (%s): Expected status %d but Found %d: %s", i, step.event, step.expectedCode, w.Code, w.Body)
+		}
+		var c campaign.Campaign
+		json.Unmarshal(w.Body.Bytes(), &c)
+		if step.expectedStatus != "" && c.Status != step.expectedStatus {
+			t.Errorf("Step %d (%s): Expected status %s but Found %s", i, step.event, step.expectedStatus, c.Status)
+		}
+		code, servedID := decide()
+		if served := code == http.StatusOK && servedID == id; served != step.expectedServed {
+			t.Errorf("Step %d (%s): Expected served to be %t but Found status %d for campaign %d", i, step.event, step.expectedServed, code, servedID)
+		}
+	}
+
+	if w := serve(r, http.MethodPost, "/campaign/99/pause", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing campaign but Found %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestPublishCampaign(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	now := time.Now()
+	w := serve(r, http.MethodPost, "/campaign", campaign.PostCampaignRequest{
+		StartTimestamp: now.Add(-time.Hour).Unix(),
+		EndTimestamp:   now.Add(time.Hour).Unix(),
+		TargetKeywords: []string{"cat"},
+		MaxImpression:  10,
+		CPM:            1.0,
+		Draft:          true,
+	})
+	var created struct {
+		CampaignID int `json:"campaign_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	if w := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}}); w.Code != http.StatusNoContent {
+		t.Errorf("Expected a draft not to be served but Found %d: %s", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodPost, fmt.Sprintf("/campaign/%d/publish", created.CampaignID), nil); w.Code != http.StatusOK {
+		t.Fatalf("Failed to publish campaign. Status: %d Body: %s", w.Code, w.Body)
+	}
+	var decision struct {
+		CampaignID int `json:"campaign_id"`
+	}
+	w = serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}})
+	json.Unmarshal(w.Body.Bytes(), &decision)
+	if w.Code != http.StatusOK || decision.CampaignID != created.CampaignID {
+		t.Errorf("Expected published campaign %d to be served but Found %d: %s", created.CampaignID, w.Code, w.Body)
+	}
+}
+
+func TestPostCampaign_Invalid(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	testcases := []struct {
+		name           string
+		body           gin.H
+		expectedFields []campaign.FieldError
+	}{
+		{
+			name: "Invalid fields",
+			body: gin.H{"target_keywords": []string{"cat"}, "max_impression": -1, "cpm": 1.0},
+			expectedFields: []campaign.FieldError{
+				{Field: "start_timestamp", Code: campaign.CodeRequired},
+				{Field: "end_timestamp", Code: campaign.CodeRequired},
+				{Field: "max_impression", Code: campaign.CodeNotPositive},
+			},
+		},
+		{
+			name: "Wrong type",
+			body: gin.H{"target_keywords": []string{"cat"}, "cpm": "high"},
+			expectedFields: []campaign.FieldError{
+				{Field: "cpm", Code: campaign.CodeInvalidType},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/campaign", tc.body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d but Found %d: %s", http.StatusBadRequest, w.Code, w.Body)
+			}
+			if diff := cmp.Diff(tc.expectedFields, validationFields(t, w), ignoreMessage); diff != "" {
+				t.Errorf("Fields mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// No campaign was created by the rejected requests.
+	var response struct {
+		Total int `json:"total"`
+	}
+	json.Unmarshal(serve(r, http.MethodGet, "/campaigns", nil).Body.Bytes(), &response)
+	if response.Total != 0 {
+		t.Errorf("Expected no campaigns but Found %d", response.Total)
+	}
+	if w := serve(r, http.MethodPost, "/addecision", gin.H{"keywords": []string{"cat"}}); w.Code != http.StatusNoContent {
+		t.Errorf("Expected nothing to be served but Found %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestMalformedParameters(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	testcases := []struct {
+		name           string
+		method         string
+		path           string
+		expectedFields []campaign.FieldError
+	}{
+		{
+			name:           "Campaign ID",
+			method:         http.MethodGet,
+			path:           "/campaign/abc",
+			expectedFields: []campaign.FieldError{{Field: "id", Code: campaign.CodeInvalidType}},
+		},
+		{
+			name:           "Campaign ID of an event",
+			method:         http.MethodPost,
+			path:           "/campaign/abc/pause",
+			expectedFields: []campaign.FieldError{{Field: "id", Code: campaign.CodeInvalidType}},
+		},
+		{
+			name:   "Campaign filters",
+			method: http.MethodGet,
+			path:   "/campaigns?active=maybe&keyword=cat&page=two",
+			expectedFields: []campaign.FieldError{
+				{Field: "active", Code: campaign.CodeInvalidType},
+				{Field: "page", Code: campaign.CodeInvalidType},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(r, tc.method, tc.path, nil)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d but Found %d: %s", http.StatusBadRequest, w.Code, w.Body)
+			}
+			if diff := cmp.Diff(tc.expectedFields, validationFields(t, w), ignoreMessage); diff != "" {
+				t.Errorf("Fields mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Shortest secret a key may have.
+const MinSecretLength = 32
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// A secret used to sign values, identified by an ID that is embedded in the
+// signed value so the key can be found again when verifying.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Signer signs values with HMAC-SHA256 and verifies them again.
+//
+// Values are signed with the first key. The other keys are only used to verify,
+// so keys can be rotated by adding a new key in front and dropping the old one
+// once nothing signed with it is in use anymore.
+type Signer struct {
+	signingKey Key
+	keys       map[string][]byte
+}
+
+func NewSigner(keys []Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	s := &Signer{
+		signingKey: keys[0],
+		keys:       make(map[string][]byte),
+	}
+	for _, key := range keys {
+		if key.ID == "" || strings.ContainsAny(key.ID, ".:,") {
+			return nil, fmt.Errorf("signing key ID %q must be non-empty and not contain '.', ':' or ','", key.ID)
+		}
+		if len(key.Secret) < MinSecretLength {
+			return nil, fmt.Errorf("signing key %s must be at least %d bytes long", key.ID, MinSecretLength)
+		}
+		if _, ok := s.keys[key.ID]; ok {
+			return nil, fmt.Errorf("signing key %s is given twice", key.ID)
+		}
+		s.keys[key.ID] = key.Secret
+	}
+	return s, nil
+}
+
+// Parses keys written as comma separated id:secret pairs with base64 encoded
+// secrets, e.g. "2024-06:c2VjcmV0...,2024-01:b2xk...".
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, pair := range strings.Split(s, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("signing key %q is not written as id:secret", pair)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", id, err)
+		}
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// Returns a key with a random secret.
+func GenerateKey(id string) (Key, error) {
+	secret := make([]byte, MinSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, err
+	}
+	return Key{ID: id, Secret: secret}, nil
+}
+
+// Signs a value for the given purpose, e.g. "impression". The result is the
+// value followed by the key ID and the signature, separated by dots, and is
+// safe to use in a URL path.
+func (s *Signer) Sign(purpose, value string) string {
+	kid := s.signingKey.ID
+	return value + "." + kid + "." + sign(s.signingKey.Secret, purpose, kid, value)
+}
+
+// Verifies a value signed for the given purpose and returns the original value.
+// Fails with ErrInvalidSignature when the value was not signed by one of the
+// keys or was signed for another purpose.
+func (s *Signer) Verify(purpose, signed string) (string, error) {
+	rest, signature, ok := cutLast(signed, ".")
+	if !ok {
+		return "", ErrInvalidSignature
+	}
+	value, kid, ok := cutLast(rest, ".")
+	if !ok {
+		return "", ErrInvalidSignature
+	}
+	secret, ok := s.keys[kid]
+	if !ok {
+		return "", ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(secret, purpose, kid, value))) {
+		return "", ErrInvalidSignature
+	}
+	return value, nil
+}
+
+func sign(secret []byte, purpose, kid, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	// Purpose and key ID never contain dots, so the message is unambiguous.
+	mac.Write([]byte(purpose + "." + kid + "." + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,118 @@
+package signing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(id string) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte(id), MinSecretLength)}
+}
+
+func TestVerify(t *testing.T) {
+	oldSigner, _ := NewSigner([]Key{testKey("old")})
+	signer, _ := NewSigner([]Key{testKey("new"), testKey("old")})
+	otherSigner, _ := NewSigner([]Key{testKey("other")})
+	signed := signer.Sign("impression", "abc")
+
+	testcases := []struct {
+		name          string
+		purpose       string
+		signed        string
+		expectedValue string
+		expectedErr   error
+	}{
+		{
+			name:          "Signed with the current key",
+			purpose:       "impression",
+			signed:        signed,
+			expectedValue: "abc",
+		},
+		{
+			name:          "Signed with a rotated out key",
+			purpose:       "impression",
+			signed:        oldSigner.Sign("impression", "abc"),
+			expectedValue: "abc",
+		},
+		{
+			name:        "Signed with an unknown key",
+			purpose:     "impression",
+			signed:      otherSigner.Sign("impression", "abc"),
+			expectedErr: ErrInvalidSignature,
+		},
+		{
+			name:        "Signed for another purpose",
+			purpose:     "click",
+			signed:      signed,
+			expectedErr: ErrInvalidSignature,
+		},
+		{
+			name:        "Tampered value",
+			purpose:     "impression",
+			signed:      "abd" + strings.TrimPrefix(signed, "abc"),
+			expectedErr: ErrInvalidSignature,
+		},
+		{
+			name:        "Key ID swapped",
+			purpose:     "impression",
+			signed:      strings.Replace(signed, ".new.", ".old.", 1),
+			expectedErr: ErrInvalidSignature,
+		},
+		{
+			name:        "Unsigned value",
+			purpose:     "impression",
+			signed:      "abc",
+			expectedErr: ErrInvalidSignature,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := signer.Verify(tc.purpose, tc.signed)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected error %v but Found %v", tc.expectedErr, err)
+			}
+			if value != tc.expectedValue {
+				t.Errorf("Expected value %q but Found %q", tc.expectedValue, value)
+			}
+		})
+	}
+}
+
+func TestNewSigner_Invalid(t *testing.T) {
+	testcases := []struct {
+		name string
+		keys []Key
+	}{
+		{name: "No keys"},
+		{name: "Short secret", keys: []Key{{ID: "a", Secret: []byte("short")}}},
+		{name: "Dot in ID", keys: []Key{testKey("a.b")}},
+		{name: "Duplicate ID", keys: []Key{testKey("a"), testKey("a")}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewSigner(tc.keys); err == nil {
+				t.Error("Expected an error but Found none.")
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	secret := bytes.Repeat([]byte("s"), MinSecretLength)
+	encoded := base64.StdEncoding.EncodeToString(secret)
+	keys, err := ParseKeys("new:" + encoded + ", old:" + encoded)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing keys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || keys[1].ID != "old" || !bytes.Equal(keys[1].Secret, secret) {
+		t.Errorf("Keys were not parsed properly. Found: %+v", keys)
+	}
+	for _, invalid := range []string{"new", "new:not base64"} {
+		if _, err := ParseKeys(invalid); err == nil {
+			t.Errorf("Expected an error parsing %q but Found none.", invalid)
+		}
+	}
+}
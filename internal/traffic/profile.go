@@ -0,0 +1,312 @@
+package traffic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kriscampos/adserver/internal/clock"
+	"github.com/kriscampos/adserver/internal/keyword"
+	"github.com/kriscampos/adserver/internal/persist"
+)
+
+const (
+	// Number of hour long buckets in a week.
+	HoursPerWeek = 7 * 24
+	// Decisions a keyword needs per hour of the week, on average, before its
+	// own curve is trusted over the overall one.
+	minDecisionsPerHour = 10
+	// Most keywords a profile learns a curve for. Decisions for other keywords
+	// are only counted in the overall curve.
+	DefaultMaxKeywords = 10000
+	// Share of the average hourly volume every hour is assumed to have, so an
+	// hour that happened to see no traffic does not stop paced campaigns.
+	smoothing = 0.05
+)
+
+// Decision volume per hour of the week, starting at midnight UTC on Sunday.
+type Curve [HoursPerWeek]uint64
+
+func (c *Curve) total() uint64 {
+	var total uint64
+	for _, n := range c {
+		total += n
+	}
+	return total
+}
+
+// Profile learns how ad decision traffic is spread over the hours of the week,
+// overall and per keyword, so campaigns can be paced against it. It is safe
+// for concurrent use.
+//
+// Until a full week has been observed the profile predicts flat traffic.
+type Profile struct {
+	mu          sync.Mutex
+	clock       clock.Clock
+	normalizer  *keyword.Normalizer
+	maxKeywords int
+	path        string
+	data        profileData
+	saver       *persist.Periodic
+}
+
+// What is persisted of a profile.
+type profileData struct {
+	Since    time.Time         `json:"since"`
+	Overall  Curve             `json:"overall"`
+	Keywords map[string]*Curve `json:"keywords"`
+}
+
+// Configures optional behavior of a Profile.
+type Option func(*Profile)
+
+func WithClock(c clock.Clock) Option {
+	return func(p *Profile) {
+		p.clock = c
+	}
+}
+
+// Sets the normalizer used for decision keywords. It should be the same one
+// the ad engine matches keywords with.
+func WithNormalizer(normalizer *keyword.Normalizer) Option {
+	return func(p *Profile) {
+		p.normalizer = normalizer
+	}
+}
+
+func WithMaxKeywords(maxKeywords int) Option {
+	return func(p *Profile) {
+		p.maxKeywords = maxKeywords
+	}
+}
+
+// Creates a profile that is only kept in memory.
+func NewProfile(opts ...Option) *Profile {
+	p := &Profile{
+		clock:       clock.New(),
+		normalizer:  keyword.NewNormalizer(keyword.DefaultConfig()),
+		maxKeywords: DefaultMaxKeywords,
+		data:        profileData{Keywords: make(map[string]*Curve)},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Creates a profile that is saved to path, loading what was learned before if
+// the file exists.
+func OpenProfile(path string, opts ...Option) (*Profile, error) {
+	p := NewProfile(opts...)
+	p.path = path
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &p.data); err != nil {
+		return nil, fmt.Errorf("reading traffic profile %s: %w", path, err)
+	}
+	if p.data.Keywords == nil {
+		p.data.Keywords = make(map[string]*Curve)
+	}
+	return p, nil
+}
+
+// Begins saving the profile every minute. Stop saves it one last time.
+func (p *Profile) Start() {
+	p.saver = persist.Every(p.clock, time.Minute, func() {
+		if err := p.Save(); err != nil {
+			log.Printf("Failed to save traffic profile: %v\n", err)
+		}
+	})
+}
+
+func (p *Profile) Stop() error {
+	p.saver.Stop()
+	return p.Save()
+}
+
+// Writes the profile to its file, replacing the previous version. Profiles
+// without a file are not saved.
+func (p *Profile) Save() error {
+	if p.path == "" {
+		return nil
+	}
+	p.mu.Lock()
+	contents, err := json.Marshal(&p.data)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return persist.WriteFile(p.path, contents)
+}
+
+// Counts an ad decision request for the given keywords at the current time.
+func (p *Profile) Record(keywords []string) {
+	normalized := p.normalizer.NormalizeAll(keywords)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.clock.Now()
+	if p.data.Since.IsZero() {
+		p.data.Since = now
+	}
+	hour := hourOfWeek(now)
+	p.data.Overall[hour]++
+	for _, k := range normalized {
+		curve, ok := p.data.Keywords[k]
+		if !ok {
+			if len(p.data.Keywords) >= p.maxKeywords {
+				continue
+			}
+			curve = &Curve{}
+			p.data.Keywords[k] = curve
+		}
+		curve[hour]++
+	}
+}
+
+// Returns the share of the traffic for the given normalized keywords between
+// from and to that arrives before at. Keywords with enough traffic of their
+// own are predicted from their own curves and others from the overall curve.
+func (p *Profile) Share(keywords []string, from, to, at time.Time) float64 {
+	if !at.After(from) {
+		return 0
+	}
+	if !at.Before(to) {
+		return 1
+	}
+	weights := p.weights(keywords)
+	return integrate(weights, from, at) / integrate(weights, from, to)
+}
+
+// Returns how many ad decisions for a normalized keyword are expected between
+// from and to, going by the average week seen so far. Until a full week has
+// been observed, decisions are expected at the average rate seen so far.
+// Keywords without a curve of their own are not expected to be requested.
+func (p *Profile) Forecast(k string, from, to time.Time) float64 {
+	if !to.After(from) {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	curve, ok := p.data.Keywords[k]
+	observed := p.clock.Now().Sub(p.data.Since)
+	if !ok || p.data.Since.IsZero() || observed <= 0 {
+		return 0
+	}
+	const week = 7 * 24 * time.Hour
+	if observed < week {
+		return float64(curve.total()) / observed.Hours() * to.Sub(from).Hours()
+	}
+	weights := &[HoursPerWeek]float64{}
+	for i, n := range curve {
+		weights[i] = float64(n)
+	}
+	return integrate(weights, from, to) / (float64(observed) / float64(week))
+}
+
+// Returns the keywords the profile learned a curve for, in order.
+func (p *Profile) Keywords() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keywords := make([]string, 0, len(p.data.Keywords))
+	for k := range p.data.Keywords {
+		keywords = append(keywords, k)
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+// Returns the curve for the given keywords as relative weights per hour.
+func (p *Profile) weights(keywords []string) *[HoursPerWeek]float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	weights := &[HoursPerWeek]float64{}
+	if p.data.Since.IsZero() || p.clock.Now().Sub(p.data.Since) < 7*24*time.Hour {
+		for i := range weights {
+			weights[i] = 1
+		}
+		return weights
+	}
+
+	var combined Curve
+	for _, k := range keywords {
+		if curve, ok := p.data.Keywords[k]; ok {
+			for i, n := range curve {
+				combined[i] += n
+			}
+		}
+	}
+	if combined.total() < minDecisionsPerHour*HoursPerWeek {
+		combined = p.data.Overall
+	}
+	floor := smoothing * float64(combined.total()) / HoursPerWeek
+	for i, n := range combined {
+		weights[i] = float64(n) + floor
+	}
+	return weights
+}
+
+// Returns the weighted number of hours between from and to.
+func integrate(weights *[HoursPerWeek]float64, from, to time.Time) float64 {
+	var weekTotal float64
+	for _, w := range weights {
+		weekTotal += w
+	}
+	// Whole weeks contribute the weight of every hour once.
+	weeks := to.Sub(from) / (7 * 24 * time.Hour)
+	sum := float64(weeks) * weekTotal
+	from = from.Add(weeks * 7 * 24 * time.Hour)
+	for from.Before(to) {
+		end := from.Truncate(time.Hour).Add(time.Hour)
+		if end.After(to) {
+			end = to
+		}
+		sum += weights[hourOfWeek(from)] * end.Sub(from).Hours()
+		from = end
+	}
+	return sum
+}
+
+func hourOfWeek(t time.Time) int {
+	t = t.UTC()
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+// What a profile has learned for a keyword, or overall when Keyword is empty.
+type Snapshot struct {
+	Keyword   string    `json:"keyword,omitempty"`
+	Since     time.Time `json:"since"`
+	Decisions uint64    `json:"decisions"`
+	// Decision volume per hour of the week, starting at midnight UTC on Sunday.
+	HourOfWeek Curve `json:"hour_of_week"`
+	// Number of keywords with a curve of their own. Only set overall.
+	Keywords int `json:"keywords,omitempty"`
+}
+
+// Returns what the profile has learned for a keyword, or overall for an empty
+// keyword.
+func (p *Profile) Snapshot(k string) Snapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snapshot := Snapshot{Since: p.data.Since}
+	if k == "" {
+		snapshot.HourOfWeek = p.data.Overall
+		snapshot.Keywords = len(p.data.Keywords)
+	} else {
+		snapshot.Keyword = p.normalizer.Normalize(k)
+		if curve, ok := p.data.Keywords[snapshot.Keyword]; ok {
+			snapshot.HourOfWeek = *curve
+		}
+	}
+	snapshot.Decisions = snapshot.HourOfWeek.total()
+	return snapshot
+}
@@ -0,0 +1,202 @@
+package traffic
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kriscampos/adserver/internal/clock"
+)
+
+// Midnight UTC on a Sunday, where the hours of the week start.
+var sunday = time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)
+
+// Records a week of traffic, with the decisions returned by traffic in every
+// hour of the week, and moves the clock past it.
+func recordWeek(p *Profile, fakeClock *clock.Fake, traffic func(hour int) (keywords []string, decisions int)) {
+	for hour := 0; hour < HoursPerWeek; hour++ {
+		fakeClock.Set(sunday.Add(time.Duration(hour) * time.Hour))
+		keywords, decisions := traffic(hour)
+		for i := 0; i < decisions; i++ {
+			p.Record(keywords)
+		}
+	}
+	fakeClock.Set(sunday.Add(7 * 24 * time.Hour))
+}
+
+func TestShare(t *testing.T) {
+	fakeClock := clock.NewFake(sunday)
+	p := NewProfile(WithClock(fakeClock))
+	// Every day has 30 decisions an hour for "day" until noon and 20 for
+	// "night" after. "night" sees enough traffic for its own curve to be used.
+	recordWeek(p, fakeClock, func(hour int) ([]string, int) {
+		if hour%24 < 12 {
+			return []string{"day"}, 30
+		}
+		return []string{"night"}, 20
+	})
+	p.Record([]string{"rare"})
+
+	testcases := []struct {
+		name     string
+		keywords []string
+		from, to time.Time
+		at       time.Time
+		expected float64
+	}{
+		{
+			name:     "Overall curve",
+			from:     sunday,
+			to:       sunday.Add(24 * time.Hour),
+			at:       sunday.Add(12 * time.Hour),
+			expected: 31.25 / (31.25 + 21.25),
+		},
+		{
+			name:     "Keyword with its own curve",
+			keywords: []string{"night"},
+			from:     sunday,
+			to:       sunday.Add(24 * time.Hour),
+			at:       sunday.Add(12 * time.Hour),
+			expected: 0.5 / (0.5 + 20.5),
+		},
+		{
+			name:     "Keyword without enough traffic uses the overall curve",
+			keywords: []string{"rare"},
+			from:     sunday,
+			to:       sunday.Add(24 * time.Hour),
+			at:       sunday.Add(12 * time.Hour),
+			expected: 31.25 / (31.25 + 21.25),
+		},
+		{
+			name:     "Across several weeks",
+			from:     sunday,
+			to:       sunday.Add(3 * 7 * 24 * time.Hour),
+			at:       sunday.Add(7 * 24 * time.Hour),
+			expected: 1.0 / 3,
+		},
+		{
+			name:     "Before the flight",
+			from:     sunday,
+			to:       sunday.Add(24 * time.Hour),
+			at:       sunday.Add(-time.Hour),
+			expected: 0,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			found := p.Share(tc.keywords, tc.from, tc.to, tc.at)
+			if math.Abs(found-tc.expected) > 1e-3 {
+				t.Errorf("Expected %f but Found %f", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestShare_FlatUntilFullWeek(t *testing.T) {
+	fakeClock := clock.NewFake(sunday)
+	p := NewProfile(WithClock(fakeClock))
+	for i := 0; i < 1000; i++ {
+		p.Record([]string{"cat"})
+	}
+	fakeClock.Advance(6 * 24 * time.Hour)
+	if found := p.Share([]string{"cat"}, sunday, sunday.Add(24*time.Hour), sunday.Add(6*time.Hour)); found != 0.25 {
+		t.Errorf("Expected flat share of 0.25 but Found %f", found)
+	}
+}
+
+func TestForecast(t *testing.T) {
+	fakeClock := clock.NewFake(sunday)
+	p := NewProfile(WithClock(fakeClock))
+	recordWeek(p, fakeClock, func(hour int) ([]string, int) {
+		if hour%24 < 12 {
+			return []string{"day"}, 30
+		}
+		return []string{"night"}, 20
+	})
+	nextWeek := sunday.Add(7 * 24 * time.Hour)
+
+	testcases := []struct {
+		name     string
+		keyword  string
+		from, to time.Time
+		expected float64
+	}{
+		{name: "A day", keyword: "day", from: nextWeek, to: nextWeek.Add(24 * time.Hour), expected: 360},
+		{name: "A night", keyword: "night", from: nextWeek, to: nextWeek.Add(24 * time.Hour), expected: 240},
+		{name: "Part of an hour", keyword: "day", from: nextWeek, to: nextWeek.Add(30 * time.Minute), expected: 15},
+		{name: "Two weeks", keyword: "night", from: nextWeek, to: nextWeek.Add(14 * 24 * time.Hour), expected: 14 * 240},
+		{name: "Unknown keyword", keyword: "cat", from: nextWeek, to: nextWeek.Add(24 * time.Hour), expected: 0},
+		{name: "Empty range", keyword: "day", from: nextWeek, to: nextWeek, expected: 0},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if found := p.Forecast(tc.keyword, tc.from, tc.to); math.Abs(found-tc.expected) > 1e-9 {
+				t.Errorf("Expected %f but Found %f", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestForecast_AverageRateUntilFullWeek(t *testing.T) {
+	fakeClock := clock.NewFake(sunday)
+	p := NewProfile(WithClock(fakeClock))
+	for i := 0; i < 100; i++ {
+		p.Record([]string{"cat"})
+	}
+	fakeClock.Advance(10 * time.Hour)
+	if found := p.Forecast("cat", sunday, sunday.Add(5*time.Hour)); math.Abs(found-50) > 1e-9 {
+		t.Errorf("Expected 50 decisions but Found %f", found)
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	p := NewProfile()
+	p.Record([]string{"Dog", "black cat"})
+	p.Record([]string{"dog"})
+	if diff := cmp.Diff([]string{"black cat", "dog"}, p.Keywords()); diff != "" {
+		t.Errorf("Keywords mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRecord_MaxKeywords(t *testing.T) {
+	p := NewProfile(WithMaxKeywords(1))
+	p.Record([]string{"Cat"})
+	p.Record([]string{"dog"})
+	if found := p.Snapshot("cat").Decisions; found != 1 {
+		t.Errorf("Expected 1 decision for cat but Found %d", found)
+	}
+	if found := p.Snapshot("dog").Decisions; found != 0 {
+		t.Errorf("Expected dog not to be tracked but Found %d decisions", found)
+	}
+	if overall := p.Snapshot(""); overall.Decisions != 2 || overall.Keywords != 1 {
+		t.Errorf("Expected 2 decisions and 1 keyword overall but Found: %+v", overall)
+	}
+}
+
+func TestOpenProfile_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.json")
+	fakeClock := clock.NewFake(sunday.Add(5 * time.Hour))
+	p, err := OpenProfile(path, WithClock(fakeClock))
+	if err != nil {
+		t.Fatalf("Unexpected error opening profile: %v", err)
+	}
+	p.Record([]string{"cat", "dog"})
+	if err := p.Save(); err != nil {
+		t.Fatalf("Unexpected error saving profile: %v", err)
+	}
+
+	reopened, err := OpenProfile(path, WithClock(fakeClock))
+	if err != nil {
+		t.Fatalf("Unexpected error reopening profile: %v", err)
+	}
+	for _, k := range []string{"", "cat", "dog"} {
+		if diff := cmp.Diff(p.Snapshot(k), reopened.Snapshot(k)); diff != "" {
+			t.Errorf("Snapshot %q mismatch (-want +got):\n%s", k, diff)
+		}
+	}
+	if found := reopened.Snapshot("cat").HourOfWeek[5]; found != 1 {
+		t.Errorf("Expected the decision in hour 5 but Found %d", found)
+	}
+}
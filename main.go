@@ -1,14 +1,140 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"os"
+	_ "time/tzdata"
+
 	"github.com/kriscampos/adserver/internal/ad_engine"
+	"github.com/kriscampos/adserver/internal/campaign"
+	"github.com/kriscampos/adserver/internal/frequency"
+	"github.com/kriscampos/adserver/internal/impression"
+	"github.com/kriscampos/adserver/internal/keyword"
 	"github.com/kriscampos/adserver/internal/router"
+	"github.com/kriscampos/adserver/internal/signing"
+	"github.com/kriscampos/adserver/internal/traffic"
 )
 
 func main() {
-	adEngine := ad_engine.NewAdEngine()
+	dbPath := flag.String("db", "", "file to persist campaigns in. Campaigns are kept in memory when empty.")
+	stem := flag.Bool("stem", false, "match plural and singular forms of keywords.")
+	stopWords := flag.Bool("stop-words", false, "ignore common English words in keywords.")
+	trafficPath := flag.String("traffic-profile", "", "file to persist the learned traffic profile in. It is kept in memory when empty.")
+	frequencyPath := flag.String("frequency-store", "", "file to persist frequency capping counts and advertiser caps in. They are kept in memory when empty.")
+	frequencyMaxUsers := flag.Int("frequency-max-users", frequency.DefaultMaxUsers, "most users frequency capping counts are kept for. The users shown a capped ad least recently are forgotten first.")
+	floorCPM := flag.Float64("floor-cpm", ad_engine.DefaultFloorCPM, "lowest CPM a campaign can win an ad decision at, which winners without a runner-up pay. Must be positive.")
+	bidIncrement := flag.Float64("bid-increment", ad_engine.DefaultBidIncrement, "CPM an ad decision's winner pays above the runner-up.")
+	ranking := flag.String("ranking", "ecpm", "how campaigns are ranked within their priority tier: cpm, ecpm or random.")
+	allocationInterval := flag.Duration("allocation-interval", ad_engine.DefaultAllocationInterval, "how often traffic is allocated to guaranteed campaigns.")
+	impressionTTL := flag.Duration("impression-ttl", impression.DefaultTTL, "how long an ad decision's impression can be recorded for.")
+	flag.Parse()
+	if *floorCPM <= 0 {
+		log.Fatalf("Invalid -floor-cpm: %v must be positive", *floorCPM)
+	}
+
+	normalizerConfig := keyword.DefaultConfig()
+	normalizerConfig.Stem = *stem
+	if *stopWords {
+		normalizerConfig.StopWords = keyword.DefaultStopWords
+	}
+	normalizer := keyword.NewNormalizer(normalizerConfig)
+
+	policy, err := ad_engine.ParseRankingPolicy(*ranking)
+	if err != nil {
+		log.Fatalf("Invalid -ranking: %v", err)
+	}
+
+	var store campaign.CampaignStore = campaign.NewMemoryStore()
+	if *dbPath != "" {
+		fileStore, err := campaign.OpenFileStore(*dbPath)
+		if err != nil {
+			log.Fatalf("Failed to open campaign store: %v", err)
+		}
+		store = fileStore
+	}
+	defer store.Close()
+
+	profile := traffic.NewProfile(traffic.WithNormalizer(normalizer))
+	if *trafficPath != "" {
+		opened, err := traffic.OpenProfile(*trafficPath, traffic.WithNormalizer(normalizer))
+		if err != nil {
+			log.Fatalf("Failed to open traffic profile: %v", err)
+		}
+		profile = opened
+	}
+	profile.Start()
+	defer profile.Stop()
+
+	frequencyStore := frequency.NewStore(frequency.WithMaxUsers(*frequencyMaxUsers))
+	if *frequencyPath != "" {
+		opened, err := frequency.OpenStore(*frequencyPath, frequency.WithMaxUsers(*frequencyMaxUsers))
+		if err != nil {
+			log.Fatalf("Failed to open frequency store: %v", err)
+		}
+		frequencyStore = opened
+	}
+	frequencyStore.Start()
+	defer frequencyStore.Stop()
+
+	campaignService := campaign.NewCampaignService(store,
+		campaign.WithNormalizer(normalizer),
+		campaign.WithTrafficCurve(profile),
+	)
+	adEngine := ad_engine.NewAdEngine(
+		ad_engine.WithNormalizer(normalizer),
+		ad_engine.WithFloorCPM(*floorCPM),
+		ad_engine.WithBidIncrement(*bidIncrement),
+		ad_engine.WithRankingPolicy(policy),
+		ad_engine.WithForecaster(profile),
+		ad_engine.WithRemainingImpressions(func(c *campaign.Campaign) int {
+			current, err := campaignService.GetCampaign(c.ID)
+			if err != nil {
+				return 0
+			}
+			return current.MaxImpression - current.ImpressionCount
+		}),
+		ad_engine.WithAllocationInterval(*allocationInterval),
+	)
 	adEngine.Start()
 	defer adEngine.Stop()
 
-	router.SetupRouter(adEngine).Run()
+	tracker := impression.NewTracker(
+		impression.WithTTL(*impressionTTL),
+		impression.WithExpiryHandler(func(d *impression.Decision) {
+			campaignService.ReleaseImpression(d.CampaignID, d.Price)
+		}),
+	)
+	tracker.Start()
+	defer tracker.Stop()
+	signer, err := newSigner()
+	if err != nil {
+		log.Fatalf("Failed to set up signing keys: %v", err)
+	}
+	r, err := router.SetupRouter(adEngine, campaignService, tracker, signer, profile, frequencyStore)
+	if err != nil {
+		log.Fatalf("Failed to set up router: %v", err)
+	}
+	r.Run()
+}
+
+// Creates the signer for tracking URLs from the keys in ADSERVER_SIGNING_KEYS.
+// Without keys a random one is used, so URLs stop working on restart.
+func newSigner() (*signing.Signer, error) {
+	var keys []signing.Key
+	if encoded := os.Getenv("ADSERVER_SIGNING_KEYS"); encoded != "" {
+		parsed, err := signing.ParseKeys(encoded)
+		if err != nil {
+			return nil, err
+		}
+		keys = parsed
+	} else {
+		log.Printf("ADSERVER_SIGNING_KEYS is not set. Signing tracking URLs with a random key.\n")
+		key, err := signing.GenerateKey("random")
+		if err != nil {
+			return nil, err
+		}
+		keys = []signing.Key{key}
+	}
+	return signing.NewSigner(keys)
 }